@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Число публикаций в ответе, если клиент его не указал
+const defaultCount = 10
+
+// Наибольшее число публикаций в одном ответе; больший count уменьшается до него
+const maxCount = 1000
+
+// Длина анонса по умолчанию, см. Config.PreviewLength
+var previewLength int
+
+// Время запуска сервера для /status
+var startedAt = time.Now()
+
+// Предельное время запроса API к базе, см. Config.DBTimeout.
+// Запрос может ждать соединения, занятого записью при опросе лент.
+var dbTimeout = 5 * time.Second
+
+// Контекст запроса к базе из обработчика API
+func dbContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), dbTimeout)
+}
+
+// Ответ на ошибку запроса к базе: 504, если запрос не уложился
+// в dbTimeout, иначе 500
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeJSONError(w, http.StatusGatewayTimeout, "Database query timed out")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
+// API для получения публикаций
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	count, err := strconv.Atoi(vars["count"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid count parameter")
+		return
+	}
+
+	listItems(w, r, count, ItemQuery{})
+}
+
+// API для получения одной публикации по идентификатору
+func itemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid id parameter")
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	item, err := store.GetItem(ctx, id)
+	if err == sql.ErrNoRows {
+		writeJSONError(w, http.StatusNotFound, "Item not found")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// API для отметки публикации прочитанной (read = true) или непрочитанной
+func readHandler(read bool) http.HandlerFunc {
+	return itemFlagHandler(func(ctx context.Context, id int) error {
+		return store.SetRead(ctx, id, read)
+	})
+}
+
+// API для добавления публикации в избранное (starred = true) и удаления из него
+func starHandler(starred bool) http.HandlerFunc {
+	return itemFlagHandler(func(ctx context.Context, id int) error {
+		return store.SetStarred(ctx, id, starred)
+	})
+}
+
+// Обработчик отметки публикации с идентификатором из пути: 204 или 404
+func itemFlagHandler(set func(ctx context.Context, id int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid id parameter")
+			return
+		}
+
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		err = set(ctx, id)
+		if err == sql.ErrNoRows {
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// API для получения избранных публикаций с общими фильтрами
+func starredHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := queryInt(r, "count", defaultCount)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid count parameter")
+		return
+	}
+
+	listItems(w, r, count, ItemQuery{Starred: true})
+}
+
+// API для поиска публикаций по заголовку и тексту без HTML-разметки,
+// без учёта регистра и диакритических знаков.
+// Поддерживаются "фразы" и префиксы слов со звёздочкой; по умолчанию
+// результаты идут по релевантности. В поле snippet — отрывок текста
+// с выделенными найденными словами, экранированный для HTML.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing q parameter")
+		return
+	}
+
+	count, err := queryInt(r, "count", defaultCount)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid count parameter")
+		return
+	}
+
+	listItems(w, r, count, ItemQuery{Search: q, Sort: "relevance", Snippet: &snippetMarkers})
+}
+
+// API для подсчёта публикаций: {"count": N}. Принимает те же
+// фильтры, что и списки публикаций, и необязательный поиск q.
+func countHandler(w http.ResponseWriter, r *http.Request) {
+	q := ItemQuery{Search: strings.TrimSpace(r.URL.Query().Get("q"))}
+	err := commonFilters(r, &q)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	count, err := store.CountItems(ctx, q)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"count": count})
+}
+
+// API для получения списка лент и их состояния.
+// Лентам без имени в конфигурации даётся заголовок из самой ленты.
+func listFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	type feedInfo struct {
+		Feed
+		Channel *FeedMeta `json:"channel,omitempty"`
+		FeedStatus
+	}
+
+	metas := feedMetas(r)
+	list := []feedInfo{}
+	for _, feed := range feeds.List() {
+		info := feedInfo{Feed: feed.Redacted(), FeedStatus: feedStatuses.get(feed.URL)}
+		if meta, ok := metas[feed.URL]; ok {
+			info.Channel = &meta
+			if info.Name == "" {
+				info.Name = meta.Title
+			}
+		}
+		list = append(list, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Сведения о лентах из хранилища; ошибка чтения не мешает ответу
+func feedMetas(r *http.Request) map[string]FeedMeta {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	metas, err := store.FeedMetas(ctx)
+	if err != nil {
+		slog.Warn("Reading feed metadata failed", "error", err)
+	}
+	return metas
+}
+
+// API для получения списка категорий с числом публикаций в каждой
+func categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	categories, err := store.Categories(ctx)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// API для мониторинга лент: состояние и история ошибок каждой ленты
+func feedHealthHandler(w http.ResponseWriter, r *http.Request) {
+	type feedHealth struct {
+		URL   string `json:"url"`
+		Name  string `json:"name,omitempty"`
+		State string `json:"state"`
+		FeedStatus
+	}
+
+	metas := feedMetas(r)
+	list := []feedHealth{}
+	for _, feed := range feeds.List() {
+		status := feedStatuses.get(feed.URL)
+		name := feed.Name
+		if name == "" {
+			name = metas[feed.URL].Title
+		}
+		list = append(list, feedHealth{feed.URL, name, status.State(), status})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// API для добавления ленты: {"url": "...", "name": "...", "period": N}
+func addFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var req Feed
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err = validateFeedURL(req.URL)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid feed URL: %v", err))
+		return
+	}
+
+	if req.Period < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid period")
+		return
+	}
+
+	if req.Proxy != "" {
+		if _, err := parseProxyURL(req.Proxy); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid proxy: %v", err))
+			return
+		}
+	}
+
+	if err := validateHeaders(req.Headers); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid headers: %v", err))
+		return
+	}
+
+	// Вместо ленты часто присылают адрес сайта: лента ищется на его странице
+	if found, err := discoverFeedURLs(r.Context(), req.URL); err == nil && len(found) > 0 && found[0] != req.URL {
+		slog.Info("Feed URL discovered", "page_url", req.URL, "feed_url", found[0])
+		req.URL = found[0]
+	}
+
+	added, err := feeds.Add(req)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !added {
+		writeJSONError(w, http.StatusConflict, "Feed already exists")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(req.Redacted())
+}
+
+// API для удаления ленты; адрес в пути должен быть экранирован
+func deleteFeedHandler(w http.ResponseWriter, r *http.Request) {
+	feedURL, err := url.PathUnescape(mux.Vars(r)["url"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid feed URL")
+		return
+	}
+
+	removed, err := feeds.Remove(feedURL)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !removed {
+		writeJSONError(w, http.StatusNotFound, "Feed not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// API для страницы состояния: период опроса в минутах, время запуска
+// и работы сервера, число лент в конфигурации и публикаций в базе
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	items, err := store.CountItems(ctx, ItemQuery{})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	list, period := feeds.Schedule()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Period        int       `json:"period"`
+		StartedAt     time.Time `json:"started_at"`
+		UptimeSeconds int64     `json:"uptime_seconds"`
+		Feeds         int       `json:"feeds"`
+		Items         int       `json:"items"`
+	}{period, startedAt.UTC(), int64(time.Since(startedAt).Seconds()), len(list), items})
+}
+
+// Проверка живости процесса, не затрагивающая ленты и базу
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Проверка готовности: база данных должна отвечать
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	err := store.Ping(ctx)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("Database unavailable: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Выборка страницы публикаций, удовлетворяющих запросу q
+// и общим фильтрам запроса. Общее число подходящих публикаций
+// передаётся в заголовке X-Total-Count.
+func listItems(w http.ResponseWriter, r *http.Request, count int, q ItemQuery) {
+	// В SQLite LIMIT -1 снимает ограничение, а при нулевом count
+	// номер страницы не сдвигает выборку
+	if count < 1 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid count parameter")
+		return
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+
+	err := commonFilters(r, &q)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	preview, err := queryInt(r, "preview", previewLength)
+	if err != nil || preview < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid preview parameter")
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid offset parameter")
+		return
+	}
+
+	if r.URL.Query().Get("page") != "" {
+		if r.URL.Query().Get("offset") != "" {
+			writeJSONError(w, http.StatusBadRequest, "Use either offset or page parameter")
+			return
+		}
+		page, err := queryInt(r, "page", 1)
+		if err != nil || page < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid page parameter")
+			return
+		}
+		offset = (page - 1) * count
+	}
+
+	q.Limit = count
+	q.Offset = offset
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	items, total, err := store.ListItems(ctx, q)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// Сокращается только ответ, в базе описание остаётся полным
+	if preview > 0 {
+		for i := range items {
+			items[i].Description = previewText(items[i].Description, preview)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	json.NewEncoder(w).Encode(items)
+}
+
+// Фильтры, общие для всех списков публикаций:
+// feed выбирает ленту-источник, category — категорию, author — автора,
+// from и to ограничивают дату публикации (RFC3339),
+// since_id оставляет публикации новее последней полученной клиентом
+// и по умолчанию выдаёт их по возрастанию id, unread=true — только
+// непрочитанные, sort задаёт порядок выдачи
+func commonFilters(r *http.Request, q *ItemQuery) error {
+	q.Feed = r.URL.Query().Get("feed")
+	q.Category = r.URL.Query().Get("category")
+	q.Author = strings.TrimSpace(r.URL.Query().Get("author"))
+
+	if value := r.URL.Query().Get("unread"); value != "" {
+		unread, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid unread parameter: expected true or false")
+		}
+		q.Unread = unread
+	}
+
+	sinceID, err := queryInt(r, "since_id", 0)
+	if err != nil || sinceID < 0 {
+		return fmt.Errorf("Invalid since_id parameter")
+	}
+	if sinceID > 0 {
+		q.SinceID = sinceID
+		q.Sort = "id_asc"
+	}
+
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		if _, ok := itemOrders[sort]; !ok {
+			return fmt.Errorf("Invalid sort parameter: expected pubdate_desc, pubdate_asc, id_desc, id_asc or relevance")
+		}
+		q.Sort = sort
+	}
+
+	bounds := []struct {
+		param string
+		dest  *time.Time
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+	}
+	for _, b := range bounds {
+		value := r.URL.Query().Get(b.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s parameter: expected RFC3339 timestamp", b.param)
+		}
+		*b.dest = t
+	}
+
+	return nil
+}
+
+// Ответ с ошибкой в формате JSON: {"error": "...", "status": 400}
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{msg, status})
+}
+
+// Ответ на запрос к несуществующему адресу API
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "Not found")
+}
+
+// Ответ на запрос с методом, который адрес API не поддерживает
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// Чтение необязательного целочисленного параметра запроса
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
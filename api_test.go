@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAPIHandlerCount(t *testing.T) {
+	s := useMemoryStore(t)
+	_, err := s.InsertItems(context.Background(), []Item{
+		{Title: "First", Link: "https://example.com/1"},
+		{Title: "Second", Link: "https://example.com/2"},
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("inserting items: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/news/{count}", apiHandler)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/news/2", http.StatusOK},
+		{"/news/5000", http.StatusOK},
+		{"/news/0", http.StatusBadRequest},
+		{"/news/-1", http.StatusBadRequest},
+		{"/news/0?page=2", http.StatusBadRequest},
+		{"/news/x", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d: %s", tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
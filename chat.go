@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Сообщения о новых публикациях во входящие вебхуки Slack или Discord
+type ChatConfig struct {
+	// "slack" или "discord"
+	Kind string `json:"kind"`
+	// Вебхук канала по умолчанию; пустой — сообщения шлются
+	// только для лент из Feeds
+	WebhookURL string `json:"webhook_url"`
+	// Вебхуки других каналов по адресу ленты
+	Feeds map[string]string `json:"feeds"`
+	// Не больше стольких сообщений в минуту на все каналы; 0 — без ограничения
+	RateLimit int `json:"rate_limit"`
+}
+
+// Сколько публикаций перечисляется в одном сообщении
+// и до скольких символов сокращается описание
+const (
+	chatMaxItems       = 10
+	chatDescriptionLen = 200
+)
+
+// Отправка сообщений в чат; нулевое значение ничего не отправляет
+type chatNotifier struct {
+	config ChatConfig
+
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+var chat = &chatNotifier{}
+
+// Проверка настроек чата
+func validateChat(c ChatConfig) error {
+	if c.WebhookURL == "" && len(c.Feeds) == 0 {
+		return nil
+	}
+	if c.Kind != "slack" && c.Kind != "discord" {
+		return fmt.Errorf("chat.kind must be \"slack\" or \"discord\", got %q", c.Kind)
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("chat.rate_limit must not be negative, got %d", c.RateLimit)
+	}
+	if c.WebhookURL != "" {
+		if err := validateFeedURL(c.WebhookURL); err != nil {
+			return fmt.Errorf("invalid chat.webhook_url: %w", err)
+		}
+	}
+	for feedURL, hook := range c.Feeds {
+		if err := validateFeedURL(hook); err != nil {
+			return fmt.Errorf("invalid chat webhook for feed %q: %w", feedURL, err)
+		}
+	}
+	return nil
+}
+
+// Сообщение о новых публикациях одной загрузки ленты в её канал.
+// Как и вебхук, сообщение отправляется через очередь, не задерживая
+// опрос; сообщения сверх лимита в минуту пропускаются.
+func (n *chatNotifier) notify(feedURL string, items []Item) {
+	hook := n.config.Feeds[feedURL]
+	if hook == "" {
+		hook = n.config.WebhookURL
+	}
+	if hook == "" || len(items) == 0 {
+		return
+	}
+
+	chatQueue.push(func(ctx context.Context) {
+		if !n.allow(time.Now()) {
+			slog.Warn("Chat notification skipped: rate limit reached", "feed_url", feedURL, "items", len(items))
+			return
+		}
+
+		err := postJSON(ctx, hook, n.message(items))
+		if err != nil {
+			slog.Warn("Chat notification failed", "feed_url", feedURL, "items", len(items), "error", err)
+		}
+	})
+}
+
+// Своя очередь, чтобы недоступный чат не задерживал вебхук и наоборот
+var chatQueue = newDeliveryQueue("chat", deliveryQueueSize)
+
+// Учёт отправленных сообщений в скользящем окне в одну минуту
+func (n *chatNotifier) allow(now time.Time) bool {
+	if n.config.RateLimit == 0 {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	recent := n.sent[:0]
+	for _, t := range n.sent {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	n.sent = recent
+	if len(n.sent) >= n.config.RateLimit {
+		return false
+	}
+	n.sent = append(n.sent, now)
+	return true
+}
+
+// Тело запроса к вебхуку: заголовки ссылками и сокращённые описания
+func (n *chatNotifier) message(items []Item) interface{} {
+	var b strings.Builder
+	for i, item := range items {
+		if i == chatMaxItems {
+			fmt.Fprintf(&b, "…and %d more\n", len(items)-chatMaxItems)
+			break
+		}
+
+		title := strings.TrimSpace(item.Title)
+		if title == "" {
+			title = item.Link
+		}
+		switch {
+		case item.Link == "":
+			b.WriteString("*" + title + "*")
+		case n.config.Kind == "slack":
+			fmt.Fprintf(&b, "<%s|%s>", item.Link, slackEscape(title))
+		default:
+			fmt.Fprintf(&b, "[%s](<%s>)", title, item.Link)
+		}
+		b.WriteByte('\n')
+
+		if text := truncate(htmlToText(item.Description), chatDescriptionLen); text != "" {
+			if n.config.Kind == "slack" {
+				text = slackEscape(text)
+			}
+			b.WriteString(text + "\n")
+		}
+	}
+
+	if n.config.Kind == "slack" {
+		return map[string]string{"text": b.String()}
+	}
+	// Сообщение Discord ограничено двумя тысячами символов
+	return map[string]string{"content": truncate(b.String(), 2000)}
+}
+
+// Экранирование управляющих символов разметки Slack
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// Сокращение текста до n символов с многоточием
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
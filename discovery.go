@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Наибольший размер страницы, в которой ищутся ссылки на ленты
+const maxDiscoverySize = 2 << 20
+
+// Типы ссылок rel="alternate", указывающих на ленты
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":  true,
+	"application/atom+xml": true,
+}
+
+// Адреса лент из тегов <link rel="alternate"> HTML-страницы,
+// разрешённые относительно её адреса base
+func discoverFeeds(body []byte, base *url.URL) []string {
+	var found []string
+	seen := map[string]bool{}
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return found
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := atom.Lookup(name)
+			// Ссылки на ленты живут в <head>; дальше <body> искать незачем
+			if tag == atom.Body {
+				return found
+			}
+			if tag != atom.Link || !hasAttr {
+				continue
+			}
+
+			var rel, typ, href string
+			for more := true; more; {
+				var key, val []byte
+				key, val, more = z.TagAttr()
+				switch string(key) {
+				case "rel":
+					rel = strings.ToLower(string(val))
+				case "type":
+					typ = strings.ToLower(strings.TrimSpace(string(val)))
+				case "href":
+					href = strings.TrimSpace(string(val))
+				}
+			}
+			if href == "" || !feedLinkTypes[typ] || !containsField(rel, "alternate") {
+				continue
+			}
+
+			ref, err := url.Parse(href)
+			if err != nil {
+				continue
+			}
+			feedURL := base.ResolveReference(ref).String()
+			if !seen[feedURL] {
+				seen[feedURL] = true
+				found = append(found, feedURL)
+			}
+		}
+	}
+}
+
+// Есть ли слово word в списке через пробелы, как в атрибуте rel
+func containsField(list, word string) bool {
+	for _, f := range strings.Fields(list) {
+		if f == word {
+			return true
+		}
+	}
+	return false
+}
+
+// Загрузка страницы и поиск лент на ней. Если по адресу
+// уже лента, возвращается он сам.
+func discoverFeedURLs(ctx context.Context, pageURL string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDiscoverySize))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := parseFeed(body); err == nil {
+		return []string{pageURL}, nil
+	}
+	// Адрес после перенаправлений, чтобы относительные ссылки разрешались верно
+	return discoverFeeds(body, resp.Request.URL), nil
+}
+
+// API для поиска лент на странице сайта: GET /api/v1/discover?url=...
+func discoverHandler(w http.ResponseWriter, r *http.Request) {
+	pageURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	err := validateFeedURL(pageURL)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid url parameter: %v", err))
+		return
+	}
+
+	found, err := discoverFeedURLs(r.Context(), pageURL)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("Fetching page: %v", err))
+		return
+	}
+	if found == nil {
+		found = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"feeds": found})
+}
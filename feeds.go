@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Лента в конфигурации. Для совместимости допускается
+// и старая запись в виде строки с URL.
+type Feed struct {
+	URL  string `json:"url"`
+	Name string `json:"name,omitempty"`
+	// Период опроса в минутах; 0 — общий период из конфигурации
+	Period int `json:"period,omitempty"`
+	// Собственный User-Agent для капризных хостов
+	UserAgent string `json:"user_agent,omitempty"`
+	// Собственный прокси для лент, доступных только через него
+	Proxy string `json:"proxy,omitempty"`
+	// Учётные данные HTTP Basic Auth для закрытых лент
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Дополнительные заголовки запроса, например токен или Referer
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (f *Feed) UnmarshalJSON(data []byte) error {
+	var feedURL string
+	if json.Unmarshal(data, &feedURL) == nil {
+		*f = Feed{URL: feedURL}
+		return nil
+	}
+
+	type plainFeed Feed
+	return json.Unmarshal(data, (*plainFeed)(f))
+}
+
+// Лента для ответов API: пароль и значения заголовков,
+// где обычно лежат токены, наружу не отдаются
+func (f Feed) Redacted() Feed {
+	if f.Password != "" {
+		f.Password = "***"
+	}
+	if len(f.Headers) > 0 {
+		headers := make(map[string]string, len(f.Headers))
+		for name := range f.Headers {
+			headers[name] = "***"
+		}
+		f.Headers = headers
+	}
+	return f
+}
+
+// Период опроса ленты с учётом общего периода в минутах
+func (f Feed) Interval(defaultPeriod int) time.Duration {
+	period := f.Period
+	if period <= 0 {
+		period = defaultPeriod
+	}
+	return time.Duration(period) * time.Minute
+}
+
+// Список опрашиваемых лент, изменяемый во время работы.
+// Изменения сохраняются в конфигурационный файл.
+type feedManager struct {
+	mu     sync.RWMutex
+	path   string
+	config Config
+	// Общий период опроса с учётом переопределений из окружения
+	period int
+}
+
+// Текущий список лент
+var feeds *feedManager
+
+func newFeedManager(path string, config Config, period int) *feedManager {
+	return &feedManager{path: path, config: config, period: period}
+}
+
+// Замена списка лент и периода опроса конфигурацией, перечитанной из файла
+func (m *feedManager) Reload(config Config, period int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config = config
+	m.period = period
+}
+
+// Копия текущего списка лент
+func (m *feedManager) List() []Feed {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Feed(nil), m.config.Feeds...)
+}
+
+// Список лент вместе с общим периодом опроса
+func (m *feedManager) Schedule() ([]Feed, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Feed(nil), m.config.Feeds...), m.period
+}
+
+// Добавление ленты. Возвращает false, если лента уже есть в списке.
+func (m *feedManager) Add(feed Feed) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.config.Feeds {
+		if f.URL == feed.URL {
+			return false, nil
+		}
+	}
+
+	m.config.Feeds = append(m.config.Feeds, feed)
+	err := m.save()
+	if err != nil {
+		m.config.Feeds = m.config.Feeds[:len(m.config.Feeds)-1]
+		return false, err
+	}
+	return true, nil
+}
+
+// Добавление нескольких лент с одной записью конфигурации.
+// Уже известные ленты и повторы в списке пропускаются.
+// Возвращает число добавленных лент.
+func (m *feedManager) AddAll(list []Feed) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	known := map[string]bool{}
+	for _, f := range m.config.Feeds {
+		known[f.URL] = true
+	}
+
+	old := m.config.Feeds
+	added := 0
+	for _, feed := range list {
+		if known[feed.URL] {
+			continue
+		}
+		known[feed.URL] = true
+		m.config.Feeds = append(m.config.Feeds, feed)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	err := m.save()
+	if err != nil {
+		m.config.Feeds = old
+		return 0, err
+	}
+	return added, nil
+}
+
+// Удаление ленты. Возвращает false, если ленты нет в списке.
+func (m *feedManager) Remove(feedURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, f := range m.config.Feeds {
+		if f.URL != feedURL {
+			continue
+		}
+
+		old := m.config.Feeds
+		m.config.Feeds = append(append([]Feed(nil), old[:i]...), old[i+1:]...)
+		err := m.save()
+		if err != nil {
+			m.config.Feeds = old
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Запись конфигурации через временный файл, чтобы не оставить её повреждённой
+func (m *feedManager) save() error {
+	data, err := json.MarshalIndent(m.config, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	err = os.WriteFile(tmp, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Состояние ленты по результатам последних загрузок
+type FeedStatus struct {
+	LastFetched *time.Time `json:"last_fetched,omitempty"`
+	// Последняя ошибка; ошибка разбора ленты, из которой удалось
+	// прочитать часть публикаций, не считается неудачей загрузки
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// Число неудачных загрузок подряд
+	Failures int `json:"consecutive_failures"`
+	// До этого момента лента не опрашивается после череды неудач
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	// Новый адрес, на который лента постоянно перенаправляет
+	MovedTo string `json:"moved_to,omitempty"`
+	// Интервал обновления в минутах, объявленный самой лентой
+	UpdateEvery int `json:"update_every,omitempty"`
+	// Сколько публикаций было в ленте при последнем разборе;
+	// пустая, но исправная лента отличается от сломанной
+	// тем, что last_fetched у неё обновляется
+	Items int `json:"items"`
+}
+
+// Состояние ленты, сохраняемое в базе между перезапусками
+type FeedState struct {
+	FeedStatus
+	cacheHeaders
+}
+
+// Дольше суток опрос не откладывается, что бы лента ни объявила
+const maxUpdateHint = 24 * time.Hour
+
+// Интервал опроса с учётом подсказок ленты: настроенный период
+// остаётся нижней границей, подсказка может только увеличить его
+func (s FeedStatus) interval(configured time.Duration) time.Duration {
+	hint := time.Duration(s.UpdateEvery) * time.Minute
+	if hint > maxUpdateHint {
+		hint = maxUpdateHint
+	}
+	if hint > configured {
+		return hint
+	}
+	return configured
+}
+
+// Лента временно исключена из опроса
+func (s FeedStatus) open(now time.Time) bool {
+	return s.OpenUntil != nil && now.Before(*s.OpenUntil)
+}
+
+// Сводное состояние: pending — ещё не загружалась,
+// open — временно не опрашивается после череды неудач,
+// failing — последняя загрузка неудачна, ok — в порядке
+func (s FeedStatus) State() string {
+	switch {
+	case s.open(time.Now()):
+		return "open"
+	case s.Failures > 0:
+		return "failing"
+	case s.LastFetched == nil:
+		return "pending"
+	default:
+		return "ok"
+	}
+}
+
+// Отключение ленты после Threshold неудач подряд. Первая пауза длится
+// Cooldown, каждая следующая неудача удваивает её до MaxCooldown.
+// Нулевой Threshold отключает механизм.
+type breakerPolicy struct {
+	Threshold   int
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+}
+
+var feedBreaker = breakerPolicy{Threshold: 5, Cooldown: 5 * time.Minute, MaxCooldown: 24 * time.Hour}
+
+// Длительность паузы после failures неудач подряд; 0 — лента не отключается
+func (p breakerPolicy) cooldown(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+	d := p.Cooldown
+	for i := p.Threshold; i < failures && d < p.MaxCooldown; i++ {
+		d *= 2
+	}
+	if d > p.MaxCooldown {
+		d = p.MaxCooldown
+	}
+	return d
+}
+
+// Состояние лент по URL
+type statusTracker struct {
+	mu       sync.Mutex
+	statuses map[string]FeedStatus
+}
+
+var feedStatuses = &statusTracker{statuses: map[string]FeedStatus{}}
+
+// Учёт результата загрузки ленты
+func (t *statusTracker) record(feedURL string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[feedURL]
+	now := time.Now()
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+		status.Failures++
+		if d := feedBreaker.cooldown(status.Failures); d > 0 {
+			until := now.Add(d)
+			status.OpenUntil = &until
+			slog.Warn("Feed disabled after repeated failures", "feed_url", feedURL,
+				"failures", status.Failures, "retry_at", until)
+		}
+	} else {
+		status.LastFetched = &now
+		status.Failures = 0
+		status.OpenUntil = nil
+	}
+	t.statuses[feedURL] = status
+}
+
+// Запоминание нового адреса ленты; пустой — лента не переезжала
+func (t *statusTracker) moved(feedURL, movedTo string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[feedURL]
+	status.MovedTo = movedTo
+	t.statuses[feedURL] = status
+}
+
+// Запоминание числа публикаций в последнем разобранном ответе ленты
+func (t *statusTracker) parsed(feedURL string, items int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[feedURL]
+	status.Items = items
+	t.statuses[feedURL] = status
+}
+
+// Запоминание ошибки разбора ленты, из которой удалось прочитать
+// часть публикаций; счётчик неудач при этом не растёт
+func (t *statusTracker) broken(feedURL string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[feedURL]
+	now := time.Now()
+	status.LastError = err.Error()
+	status.LastErrorAt = &now
+	t.statuses[feedURL] = status
+}
+
+// Запоминание объявленного лентой интервала обновления
+func (t *statusTracker) hint(feedURL string, minutes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[feedURL]
+	status.UpdateEvery = minutes
+	t.statuses[feedURL] = status
+}
+
+// Восстановление состояний, сохранённых до перезапуска
+func (t *statusTracker) restore(states map[string]FeedState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for feedURL, state := range states {
+		t.statuses[feedURL] = state.FeedStatus
+	}
+}
+
+// Состояние ленты; для ещё не загружавшейся ленты оно пустое
+func (t *statusTracker) get(feedURL string) FeedStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.statuses[feedURL]
+}
+
+// Проверка адреса ленты: допускаются только абсолютные http(s) URL
+func validateFeedURL(feedURL string) error {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// Проверка дополнительных заголовков ленты
+func validateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid value for header %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Очередь запросов к хостам лент: к одному хосту одновременно идёт
+// не больше одного запроса, и между запросами выдерживается пауза.
+// Ленты разных хостов загружаются параллельно.
+type hostQueue struct {
+	// Минимальная пауза между концом одного запроса к хосту и началом следующего
+	delay time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+type hostSlot struct {
+	// Занят, пока к хосту идёт запрос
+	busy chan struct{}
+	// Когда закончился последний запрос; меняется только владельцем busy
+	last time.Time
+}
+
+func newHostQueue(delay time.Duration) *hostQueue {
+	return &hostQueue{delay: delay, hosts: map[string]*hostSlot{}}
+}
+
+// Очередь хостов, см. Config.HostDelay
+var feedHosts = newHostQueue(time.Second)
+
+// Хост ленты в нижнем регистре; у негодного адреса — сам адрес
+func feedHost(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil || u.Hostname() == "" {
+		return feedURL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Ожидание очереди к хосту и паузы после предыдущего запроса.
+// release нужно вызвать по окончании запроса.
+func (q *hostQueue) acquire(ctx context.Context, host string) (release func(), err error) {
+	q.mu.Lock()
+	slot := q.hosts[host]
+	if slot == nil {
+		slot = &hostSlot{busy: make(chan struct{}, 1)}
+		q.hosts[host] = slot
+	}
+	q.mu.Unlock()
+
+	select {
+	case slot.busy <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if wait := time.Until(slot.last.Add(q.delay)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			<-slot.busy
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		slot.last = time.Now()
+		<-slot.busy
+	}, nil
+}
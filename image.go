@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Элементы Media RSS публикации: миниатюры, медиафайлы и их группы
+type MediaSet struct {
+	Thumbnails []MediaRef `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	Contents   []MediaRef `xml:"http://search.yahoo.com/mrss/ content"`
+	Groups     []MediaSet `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+// Ссылка на медиафайл в media:thumbnail или media:content
+type MediaRef struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+// Адреса изображений из Media RSS по порядку: сначала миниатюры,
+// затем медиафайлы-изображения, затем то же из групп
+func (m MediaSet) images() []string {
+	var urls []string
+	for _, thumb := range m.Thumbnails {
+		urls = append(urls, thumb.URL)
+	}
+	for _, content := range m.Contents {
+		if content.Medium == "image" || strings.HasPrefix(content.Type, "image/") {
+			urls = append(urls, content.URL)
+		}
+	}
+	for _, group := range m.Groups {
+		urls = append(urls, group.images()...)
+	}
+	return urls
+}
+
+// Главное изображение публикации: Media RSS, обложка выпуска подкаста, вложение-картинка или
+// первый <img> в тексте. Относительный адрес разрешается от ссылки
+// публикации; пустая строка — изображения нет.
+func leadImage(item Item) string {
+	candidates := item.MediaSet.images()
+	if item.Podcast != nil {
+		candidates = append(candidates, item.Podcast.Image)
+	}
+	for _, e := range item.Enclosures {
+		if strings.HasPrefix(e.Type, "image/") {
+			candidates = append(candidates, e.URL)
+		}
+	}
+	candidates = append(candidates, firstImage(item.Content), firstImage(item.Description))
+
+	base, _ := url.Parse(strings.TrimSpace(item.Link))
+	for _, candidate := range candidates {
+		if image := resolveImage(base, candidate); image != "" {
+			return image
+		}
+	}
+	return ""
+}
+
+// Абсолютный http(s) адрес изображения или пустая строка
+func resolveImage(base *url.URL, raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+// Адрес первого изображения в HTML-фрагменте. Счётчики
+// размером 1×1 изображениями не считаются.
+func firstImage(fragment string) string {
+	if !strings.Contains(fragment, "<img") && !strings.Contains(fragment, "<IMG") {
+		return ""
+	}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if atom.Lookup(name) != atom.Img || !hasAttr {
+				continue
+			}
+			var src, width, height string
+			for more := true; more; {
+				var key, value []byte
+				key, value, more = tokenizer.TagAttr()
+				switch string(key) {
+				case "src":
+					src = string(value)
+				case "width":
+					width = string(value)
+				case "height":
+					height = string(value)
+				}
+			}
+			if src != "" && !(width == "1" && height == "1") {
+				return src
+			}
+		}
+	}
+}
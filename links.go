@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Параметры запроса, которые добавляют системы аналитики; на содержимое не влияют
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"yclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+	"igshid":  true,
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// Приведение ссылки к виду для сравнения: схема и хост в нижнем регистре,
+// без порта по умолчанию, фрагмента и параметров отслеживания,
+// остальные параметры отсортированы. Не-URL возвращается как есть.
+func normalizeURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if isTrackingParam(name) {
+				delete(query, name)
+			}
+		}
+		// Encode сортирует параметры по имени
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// Ключи дедупликации, полученные из ссылок, а не из GUID ленты или хэша
+func isURLKey(key string) bool {
+	return !strings.HasPrefix(key, "guid:") && !strings.HasPrefix(key, "sha256:")
+}
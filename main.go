@@ -1,107 +1,1075 @@
 package main
 
 import (
-	"database/sql"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "modernc.org/sqlite"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/sync/errgroup"
 )
 
-// Конфигурационная структура
+// Конфигурационная структура. Значения берутся по убыванию приоритета
+// из флагов командной строки, переменных окружения NEWS_*, файла
+// конфигурации и значений по умолчанию.
 type Config struct {
-	Feeds  []string `json:"feeds"`
-	Period int      `json:"period"`
+	Feeds []Feed `json:"feeds"`
+	// Период опроса в минутах для лент, не задавших свой
+	Period int `json:"period"`
+	// Время на завершение работы в секундах
+	ShutdownTimeout int `json:"shutdown_timeout"`
+	// Таймаут загрузки одной ленты в секундах
+	FetchTimeout int `json:"fetch_timeout"`
+	// Таймаут запроса API к базе в секундах
+	DBTimeout int `json:"db_timeout"`
+	// Число попыток загрузки и начальная задержка между ними в секундах
+	RetryAttempts int `json:"retry_attempts"`
+	RetryDelay    int `json:"retry_delay"`
+	// Заголовок User-Agent для запросов к лентам
+	UserAgent string `json:"user_agent"`
+	// Уровень журнала: debug, info, warn или error
+	LogLevel string `json:"log_level"`
+	// Максимальное число одновременных загрузок лент
+	MaxConcurrency int `json:"max_concurrency"`
+	// Очищать HTML в описаниях от опасной разметки перед сохранением
+	SanitizeHTML bool `json:"sanitize_html"`
+	// Хранилище: "sqlite" или "postgres", и путь к файлу SQLite или строка подключения.
+	// Путь можно переопределить переменной окружения DB_PATH и флагом -db;
+	// ":memory:" или "memory" — база SQLite в памяти, не переживающая перезапуск.
+	Driver string `json:"driver"`
+	DBPath string `json:"db_path"`
+	// Срок хранения публикаций в днях; 0 — хранить бессрочно
+	RetentionDays int `json:"retention_days"`
+	// Сколько самых свежих публикаций хранить; 0 — без ограничения
+	MaxItems int `json:"max_items"`
+	// Источники, которым разрешены запросы к API из браузера; "*" — любые
+	AllowedOrigins []string `json:"allowed_origins"`
+	// Ключ, без которого API отвечает 401; пустой — API открыт
+	APIKey string `json:"api_key"`
+	// После стольких неудач подряд лента временно не опрашивается; 0 — никогда.
+	// Пауза в минутах удваивается с каждой следующей неудачей.
+	BreakerThreshold int `json:"breaker_threshold"`
+	BreakerCooldown  int `json:"breaker_cooldown"`
+	// Адрес HTTP-сервера, например "127.0.0.1:9000"
+	Listen string `json:"listen"`
+	// Сертификат и ключ в PEM; если заданы оба, сервер работает по HTTPS
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Адрес, где HTTP-запросы перенаправляются на HTTPS, например ":80";
+	// используется только вместе с TLS
+	HTTPRedirect string `json:"http_redirect"`
+	// Прокси для загрузки лент, например "http://proxy:3128";
+	// пустой — берётся из HTTP_PROXY, HTTPS_PROXY и NO_PROXY
+	Proxy string `json:"proxy"`
+	// Сколько перенаправлений подряд разрешено при загрузке ленты
+	// и запрещены ли перенаправления на другой хост
+	MaxRedirects      int  `json:"max_redirects"`
+	SameHostRedirects bool `json:"same_host_redirects"`
+	// Максимальный размер ленты после распаковки в мегабайтах
+	MaxBodyMB int `json:"max_body_mb"`
+	// Публикации с этими словами в заголовке или тексте и ссылками
+	// на эти домены (вместе с поддоменами) не сохраняются
+	MuteKeywords []string `json:"mute_keywords"`
+	BlockDomains []string `json:"block_domains"`
+	// Адрес, куда после каждой загрузки ленты отправляется
+	// POST с массивом её новых публикаций
+	WebhookURL string `json:"webhook_url"`
+	// Сообщения о новых публикациях в Slack или Discord
+	Chat ChatConfig `json:"chat"`
+	// Что делать с публикациями без заголовка: "keep" — сохранять как есть,
+	// "skip" — пропускать те, у которых нет и ссылки, "placeholder" — брать
+	// заголовок из первого предложения описания
+	UntitledItems string `json:"untitled_items"`
+	// Длина анонса в символах для списков публикаций в API, если
+	// клиент не передал preview; 0 — описание отдаётся целиком
+	PreviewLength int `json:"preview_length"`
+	// Метки вокруг найденных слов в отрывках результатов поиска
+	SnippetMarkers SnippetMarkers `json:"snippet_markers"`
+	// Пауза в секундах между запросами к одному хосту лент;
+	// запросы к хосту в любом случае идут по одному
+	HostDelay int `json:"host_delay"`
+	// Запросов к API в секунду с одного IP и допустимый всплеск;
+	// 0 отключает ограничение
+	RateLimit int `json:"rate_limit"`
+	RateBurst int `json:"rate_burst"`
 }
 
-// Структура для RSS
-type RSS struct {
-	Channel struct {
-		Items []Item `xml:"item"`
-	} `xml:"channel"`
+// Сведения о самой ленте: канал RSS или элемент feed в Atom
+type FeedMeta struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Link        string `json:"link,omitempty"`
+	// Объявленный лентой интервал обновления в минутах; не хранится
+	UpdateEvery int `json:"-"`
+}
+
+// Пространство имён модуля Syndication для RSS
+const syndicationNS = "http://purl.org/rss/1.0/modules/syndication/"
+
+// Интервал обновления в минутах по подсказкам ленты: ttl
+// важнее sy:updatePeriod, 0 — подсказок нет или они негодны
+func updateInterval(ttl, period, frequency string) int {
+	if minutes, err := strconv.Atoi(strings.TrimSpace(ttl)); err == nil && minutes > 0 {
+		return minutes
+	}
+
+	periods := map[string]int{
+		"hourly":  60,
+		"daily":   24 * 60,
+		"weekly":  7 * 24 * 60,
+		"monthly": 30 * 24 * 60,
+		"yearly":  365 * 24 * 60,
+	}
+	minutes, ok := periods[strings.ToLower(strings.TrimSpace(period))]
+	if !ok {
+		return 0
+	}
+	times := 1
+	if frequency = strings.TrimSpace(frequency); frequency != "" {
+		n, err := strconv.Atoi(frequency)
+		if err != nil || n < 1 {
+			return 0
+		}
+		times = n
+	}
+	return minutes / times
 }
 
 // Структура для Item
 type Item struct {
-	Title       string `xml:"title"`
-	Description string `xml:"description"`
-	Link        string `xml:"link"`
-	PubDate     string `xml:"pubDate"`
+	// Одноимённые элементы других пространств имён. Поле без пространства
+	// имён принимает элемент из любого, а элемент достаётся первому
+	// подходящему полю, поэтому эти поля объявлены до Title, Description и Link.
+	ItunesTitle      string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title" json:"-"`
+	MediaTitle       string     `xml:"http://search.yahoo.com/mrss/ title" json:"-"`
+	MediaDescription string     `xml:"http://search.yahoo.com/mrss/ description" json:"-"`
+	AtomLinks        []AtomLink `xml:"http://www.w3.org/2005/Atom link" json:"-"`
+	// Основные элементы item
+	ID          int      `xml:"-" json:"id"`
+	Title       string   `xml:"title"`
+	Description string   `xml:"description"`
+	Link        string   `xml:"link"`
+	PubDate     string   `xml:"pubDate"`
+	FeedURL     string   `xml:"-" json:"feed_url"`
+	GUID        GUID     `xml:"guid" json:"guid"`
+	Categories  []string `xml:"category" json:"categories,omitempty"`
+	// Полный текст из content:encoded, если лента даёт в description лишь анонс
+	Content string `xml:"http://purl.org/rss/1.0/modules/content/ encoded" json:"content,omitempty"`
+	// Вложения из ленты; сохраняется только первое
+	Enclosures []Enclosure `xml:"enclosure" json:"-"`
+	Enclosure  *Enclosure  `xml:"-" json:"enclosure,omitempty"`
+	// Число слов видимого текста и время чтения в минутах
+	WordCount   int `xml:"-" json:"word_count"`
+	ReadingTime int `xml:"-" json:"reading_time"`
+	// Элементы Media RSS, из которых берётся главное изображение
+	MediaSet `json:"-"`
+	// Главное изображение публикации для миниатюры
+	ImageURL string `xml:"-" json:"image_url,omitempty"`
+	// Теги itunes у выпусков подкастов
+	ItunesTags `json:"-"`
+	Podcast    *Podcast `xml:"-" json:"podcast,omitempty"`
+	// Автор из <author> или dc:creator; объявлены после тегов itunes,
+	// чтобы itunes:author не попал в поле без пространства имён
+	RSSAuthor string `xml:"author" json:"-"`
+	Creator   string `xml:"http://purl.org/dc/elements/1.1/ creator" json:"-"`
+	Author    string `xml:"-" json:"author,omitempty"`
+	// Дата публикации из dc:date (ISO 8601) для лент без pubDate
+	DCDate string `xml:"http://purl.org/dc/elements/1.1/ date" json:"-"`
+	// Отрывок с найденными словами между метками, только в результатах поиска
+	Snippet string `xml:"-" json:"snippet,omitempty"`
+	// Прочитана ли публикация; отметка одна на всех клиентов
+	Read bool `xml:"-" json:"read"`
+	// Избранная публикация не удаляется при очистке
+	Starred bool `xml:"-" json:"starred"`
+}
+
+// Автор публикации RSS: dc:creator, затем <author>, в котором
+// по спецификации адрес почты и имя в скобках, затем itunes:author
+func (item Item) author() string {
+	if creator := strings.TrimSpace(item.Creator); creator != "" {
+		return creator
+	}
+	author := strings.TrimSpace(item.RSSAuthor)
+	if open := strings.Index(author, "("); open >= 0 && strings.HasSuffix(author, ")") {
+		if name := strings.TrimSpace(author[open+1 : len(author)-1]); name != "" {
+			return name
+		}
+	}
+	if author != "" {
+		return author
+	}
+	return strings.TrimSpace(item.RawAuthor)
+}
+
+// Медиафайл, приложенный к публикации (подкасты, изображения)
+type Enclosure struct {
+	URL  string `xml:"url,attr" json:"url"`
+	Type string `xml:"type,attr" json:"type,omitempty"`
+	// Длина в байтах; в лентах встречаются нечисловые значения, поэтому строка
+	Length string `xml:"length,attr" json:"-"`
+	Size   int64  `xml:"-" json:"length,omitempty"`
+}
+
+// Первое вложение с адресом
+func (item Item) firstEnclosure() (Enclosure, bool) {
+	for _, e := range item.Enclosures {
+		if e.URL != "" {
+			e.Size, _ = strconv.ParseInt(strings.TrimSpace(e.Length), 10, 64)
+			return e, true
+		}
+	}
+	return Enclosure{}, false
+}
+
+// Уникальный идентификатор публикации: guid в RSS, id в Atom
+type GUID struct {
+	Value string `xml:",chardata"`
+	// В RSS отсутствие атрибута означает true
+	IsPermaLink string `xml:"isPermaLink,attr"`
+}
+
+// Является ли GUID постоянной ссылкой на публикацию
+func (g GUID) PermaLink() bool {
+	if g.Value == "" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(g.IsPermaLink)) {
+	case "", "true", "1", "yes":
+		return true
+	}
+	return false
+}
+
+func (g GUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.Value)
+}
+
+// Структура для Atom
+type Atom struct {
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// Структура для entry в Atom
+type AtomEntry struct {
+	// Идёт первым: иначе media:content попал бы в поле Content
+	MediaSet
+	Authors []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Title      AtomText   `xml:"title"`
+	Summary    AtomText   `xml:"summary"`
+	Content    AtomText   `xml:"content"`
+	Links      []AtomLink `xml:"link"`
+	ID         string     `xml:"id"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Updated   string `xml:"updated"`
+	Published string `xml:"published"`
+}
+
+// Текстовая конструкция Atom (text, html или xhtml)
+type AtomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// Ссылка в Atom
+type AtomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+func (t AtomText) String() string {
+	if t.Type == "xhtml" {
+		return strings.TrimSpace(t.Inner)
+	}
+	return strings.TrimSpace(t.Text)
+}
+
+// Преобразование entry в Item
+func (e AtomEntry) Item() Item {
+	item := Item{
+		Title:       e.Title.String(),
+		Description: e.Summary.String(),
+		PubDate:     e.Published,
+		GUID:        GUID{Value: strings.TrimSpace(e.ID), IsPermaLink: "false"},
+		Content:     e.Content.String(),
+		MediaSet:    e.MediaSet,
+	}
+	if item.Description == "" {
+		item.Description = item.Content
+	}
+	if item.PubDate == "" {
+		item.PubDate = e.Updated
+	}
+	for _, category := range e.Categories {
+		item.Categories = append(item.Categories, category.Term)
+	}
+	var authors []string
+	for _, author := range e.Authors {
+		if name := strings.TrimSpace(author.Name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	item.Author = strings.Join(authors, ", ")
+	for _, link := range e.Links {
+		if link.Rel == "enclosure" {
+			item.Enclosures = append(item.Enclosures, Enclosure{URL: link.Href, Type: link.Type, Length: link.Length})
+		}
+	}
+	for _, link := range e.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			item.Link = link.Href
+			break
+		}
+	}
+	if item.Link == "" && len(e.Links) > 0 {
+		item.Link = e.Links[0].Href
+	}
+	return item
+}
+
+// Форматы дат, встречающиеся в RSS и Atom
+var pubDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	// Варианты W3C-DTF из dc:date: без секунд и с точностью до месяца
+	"2006-01-02T15:04Z07:00",
+	"2006-01",
+}
+
+// Формат, в котором даты хранятся в базе: в UTC он сортируется хронологически
+const storedDateLayout = time.RFC3339
+
+// Общий клиент для загрузки лент. Соединения с хостами
+// переиспользуются между лентами и между опросами.
+var httpClient = &http.Client{
+	Timeout:       15 * time.Second,
+	Transport:     newTransport(),
+	CheckRedirect: feedRedirects.check,
 }
 
-var db *sql.DB
+// Транспорт с пулом соединений, рассчитанным на десятки лент
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 8
+	transport.IdleConnTimeout = 5 * time.Minute
+	transport.Proxy = feedProxy
+	return transport
+}
 
-// Инициализация базы данных
-func initDB() {
-	var err error
-	db, err = sql.Open("sqlite", "./rss.db")
+// Ограничения на перенаправления при загрузке лент
+type redirectPolicy struct {
+	Max      int
+	SameHost bool
+}
+
+var feedRedirects = redirectPolicy{Max: 5}
+
+// Ошибка запроса, остановленного политикой перенаправлений;
+// такой запрос не повторяется
+var errRedirectRefused = errors.New("redirect refused")
+
+// Проверка очередного перенаправления; via — уже выполненные запросы
+func (p *redirectPolicy) check(req *http.Request, via []*http.Request) error {
+	if len(via) > p.Max {
+		return fmt.Errorf("%w: more than %d redirects", errRedirectRefused, p.Max)
+	}
+	if p.SameHost && req.URL.Hostname() != via[0].URL.Hostname() {
+		return fmt.Errorf("%w: %s is on another host", errRedirectRefused, req.URL)
+	}
+	return nil
+}
+
+// Все ли перенаправления, приведшие к ответу, были постоянными (301 и 308)
+func movedPermanently(resp *http.Response) bool {
+	moved := false
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		if r.StatusCode != http.StatusMovedPermanently && r.StatusCode != http.StatusPermanentRedirect {
+			return false
+		}
+		moved = true
+	}
+	return moved
+}
+
+// Прокси из конфигурации; nil — прокси из окружения
+var proxyURL *url.URL
+
+// Ключ контекста запроса с прокси, заданным для отдельной ленты
+type proxyKey struct{}
+
+// Выбор прокси для запроса: сначала прокси ленты, затем общий
+// из конфигурации, затем из переменных окружения
+func feedProxy(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(proxyKey{}).(*url.URL); ok {
+		return proxy, nil
+	}
+	if proxyURL != nil {
+		return http.ProxyURL(proxyURL)(req)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// Разбор адреса прокси; поддерживаются http, https и socks5
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host")
 	}
+	return u, nil
+}
+
+// User-Agent по умолчанию: стандартный Go-http-client часто блокируют
+const defaultUserAgent = "go_news_rss/1.0 (+https://github.com/onauryzbaev/go_news_rss)"
+
+var userAgent = defaultUserAgent
+
+// Предел размера ленты в байтах: больший ответ считается ошибкой
+var maxBodySize int64 = 10 << 20
+
+// Повторные попытки загрузки с экспоненциальной задержкой
+type retryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+}
+
+var fetchRetry = retryPolicy{Attempts: 3, BaseDelay: time.Second}
 
-	createTableSQL := `CREATE TABLE IF NOT EXISTS rss (
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,		
-		"title" TEXT,
-		"description" TEXT,
-		"link" TEXT,
-		"pubDate" DATETIME
-	);`
+// Заголовки ответа для условных запросов к ленте
+type cacheHeaders struct {
+	ETag         string
+	LastModified string
+}
+
+// Заголовки последнего успешного ответа по URL ленты
+var (
+	feedCacheMu sync.Mutex
+	feedCache   = map[string]cacheHeaders{}
+)
 
-	_, err = db.Exec(createTableSQL)
+// Сохранение состояния ленты и её заголовков в базе, чтобы после
+// перезапуска условные запросы и отключение лент продолжили работать
+func saveFeedState(ctx context.Context, feedURL string) {
+	feedCacheMu.Lock()
+	cached := feedCache[feedURL]
+	feedCacheMu.Unlock()
+
+	// Состояние сохраняется и при отмене загрузки во время остановки
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	err := store.SaveFeedState(ctx, feedURL, FeedState{feedStatuses.get(feedURL), cached})
 	if err != nil {
-		log.Fatal(err)
+		slog.Warn("Storing feed state failed", "feed_url", feedURL, "error", err)
 	}
 }
 
-// Функция для добавления публикации в базу данных
-func insertItem(item Item) {
-	insertSQL := `INSERT INTO rss (title, description, link, pubDate) VALUES (?, ?, ?, ?)`
-	_, err := db.Exec(insertSQL, item.Title, item.Description, item.Link, item.PubDate)
+// Загрузка состояний лент, сохранённых saveFeedState
+func loadFeedStates(ctx context.Context) error {
+	states, err := store.FeedStates(ctx)
 	if err != nil {
-		log.Printf("Error inserting item: %v", err)
+		return err
+	}
+	feedStatuses.restore(states)
+	feedCacheMu.Lock()
+	for feedURL, state := range states {
+		feedCache[feedURL] = state.cacheHeaders
+	}
+	feedCacheMu.Unlock()
+	return nil
+}
+
+// Ключ дедупликации: GUID, затем ссылка, а если нет и её — хэш
+// заголовка и даты. GUID, не являющийся ссылкой, уникален лишь
+// в пределах своей ленты, поэтому дополняется её адресом.
+// Ссылки в ключе нормализуются, в поле link остаётся исходная.
+func itemKey(item Item) string {
+	guid := strings.TrimSpace(item.GUID.Value)
+	if guid != "" {
+		if item.GUID.PermaLink() {
+			return normalizeURL(guid)
+		}
+		return "guid:" + item.FeedURL + "|" + guid
+	}
+	if item.Link != "" {
+		return normalizeURL(item.Link)
+	}
+	return "sha256:" + contentHash(item)
+}
+
+// Хэш содержимого для публикаций без GUID и ссылки. Дата берётся
+// в формате хранения, чтобы хэш совпадал с вычисленным по базе.
+func contentHash(item Item) string {
+	date := strings.TrimSpace(item.PubDate)
+	if t, err := parsePubDate(date); err == nil {
+		date = t.UTC().Format(storedDateLayout)
+	}
+	sum := sha256.Sum256([]byte(item.Title + "\x00" + date + "\x00" + item.Description))
+	return hex.EncodeToString(sum[:])
+}
+
+// Удаление повторов внутри одного документа ленты: остаётся
+// первое вхождение публикации с данным ключом дедупликации
+func dedupItems(items []Item) []Item {
+	seen := make(map[string]bool, len(items))
+	unique := items[:0]
+	for _, item := range items {
+		key := itemKey(item)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, item)
+	}
+	return unique
+}
+
+// Политика для публикаций без заголовка, см. Config.UntitledItems
+var untitledItems = "keep"
+
+// Применение политики к публикациям без заголовка. Публикации,
+// в которых нет ни заголовка, ни ссылки, ни описания, не сохраняются
+// ни при какой политике. Возвращает оставшиеся и число пропущенных.
+func fixUntitled(items []Item, policy string) ([]Item, int) {
+	kept := items[:0]
+	for _, item := range items {
+		if strings.TrimSpace(item.Title) == "" {
+			if policy == "placeholder" {
+				item.Title = firstSentence(htmlToText(item.Description))
+			}
+			if strings.TrimSpace(item.Title) == "" && item.Link == "" && (policy != "keep" || item.Description == "") {
+				continue
+			}
+		}
+		kept = append(kept, item)
 	}
+	return kept, len(items) - len(kept)
 }
 
-// Обработка RSS
-func fetchRSS(url string, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Разбор даты публикации в одном из распространённых форматов
+func parsePubDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	for _, layout := range pubDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", value)
+}
+
+// Обработка RSS с записью результата в состояние ленты
+func fetchRSS(ctx context.Context, feed Feed) error {
+	err := fetchFeed(ctx, httpClient, feed)
+	feedStatuses.record(feed.URL, err)
+	saveFeedState(ctx, feed.URL)
+	if err != nil {
+		slog.Error("Feed fetch failed", "feed_url", feed.URL, "error", err)
+		return fmt.Errorf("%s: %w", feed.URL, err)
+	}
+	return nil
+}
+
+// Загрузка ленты и сохранение её публикаций. Клиент передаётся
+// явно, чтобы ленту можно было загрузить, например, с httptest.Server.
+func fetchFeed(ctx context.Context, client *http.Client, feed Feed) error {
+	url := feed.URL
+	if feed.Proxy != "" {
+		proxy, err := parseProxyURL(feed.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+		ctx = context.WithValue(ctx, proxyKey{}, proxy)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	feedCacheMu.Lock()
+	cached := feedCache[url]
+	feedCacheMu.Unlock()
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	if feed.UserAgent != "" {
+		req.Header.Set("User-Agent", feed.UserAgent)
+	} else {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	// Заголовок Authorization клиент не передаёт при перенаправлении на другой хост
+	if feed.Username != "" || feed.Password != "" {
+		req.SetBasicAuth(feed.Username, feed.Password)
+	}
+	for name, value := range feed.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// Явный заголовок отключает автоматическую распаковку в http.Transport,
+	// поэтому ответ распаковывается ниже вручную
+	req.Header.Set("Accept-Encoding", "gzip")
 
-	resp, err := http.Get(url)
+	resp, err := fetchRetry.do(ctx, client, req)
 	if err != nil {
-		log.Printf("Error fetching URL %s: %v", url, err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// Постоянный переезд ленты отмечается в её состоянии,
+	// чтобы адрес в конфигурации можно было обновить
+	movedTo := ""
+	if final := resp.Request.URL.String(); final != url {
+		if movedPermanently(resp) {
+			movedTo = final
+			slog.Warn("Feed moved permanently", "feed_url", url, "final_url", final)
+		} else {
+			slog.Debug("Feed redirected", "feed_url", url, "final_url", final)
+		}
+	}
+	feedStatuses.moved(url, movedTo)
+
+	if resp.StatusCode == http.StatusNotModified {
+		slog.Debug("Feed not modified", "feed_url", url, "status", resp.StatusCode)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var reader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("decompressing response body: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	// Предел считается после распаковки, чтобы не пропустить gzip-бомбу
+	body, err := ioutil.ReadAll(io.LimitReader(reader, maxBodySize+1))
+	if err != nil {
+		return fmt.Errorf("reading response body: %w", err)
+	}
+	if int64(len(body)) > maxBodySize {
+		return fmt.Errorf("response body exceeds %d bytes", maxBodySize)
+	}
+
+	meta, items, err := parseFeed(body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return
+		if len(items) == 0 {
+			if errors.Is(err, errNotAFeed) {
+				err = fmt.Errorf("%w (Content-Type %q)", err, resp.Header.Get("Content-Type"))
+			}
+			// Вместо ленты пришла страница сайта: подсказываем адреса её лент
+			if found := discoverFeeds(body, resp.Request.URL); len(found) > 0 {
+				return fmt.Errorf("parsing feed: %w; the page links to feeds: %s", err, strings.Join(found, ", "))
+			}
+			return fmt.Errorf("parsing feed: %w", err)
+		}
+		slog.Warn("Feed is partially broken", "feed_url", url, "items", len(items), "error", err)
+		feedStatuses.broken(url, fmt.Errorf("parsing feed: %w", err))
+	} else {
+		// Сломанная лента могла не дойти до ttl, и прежняя подсказка сохраняется
+		feedStatuses.hint(url, meta.UpdateEvery)
+	}
+
+	fetched := len(items)
+	feedStatuses.parsed(url, fetched)
+	if fetched == 0 {
+		slog.Debug("Feed has no items", "feed_url", url)
+	}
+
+	for i := range items {
+		items[i].FeedURL = url
+	}
+	kept := muteFilter.apply(items)
+	muted := len(items) - len(kept)
+	if muted > 0 {
+		slog.Debug("Skipping muted items in feed", "feed_url", url, "muted", muted)
+		items = kept
+	}
+	items, skipped := fixUntitled(items, untitledItems)
+	if skipped > 0 {
+		slog.Debug("Skipping items without title", "feed_url", url, "skipped", skipped)
+	}
+	if unique := dedupItems(items); len(unique) < len(items) {
+		slog.Debug("Skipping duplicate items in feed", "feed_url", url, "duplicates", len(items)-len(unique))
+		items = unique
+	}
+
+	added, err := store.InsertItems(ctx, items, time.Now())
+	if err != nil {
+		return fmt.Errorf("storing items: %w", err)
+	}
+	hub.broadcast(added)
+	notifyWebhook(added)
+	chat.notify(url, added)
+
+	if meta != (FeedMeta{}) {
+		err := store.SaveFeedMeta(ctx, url, meta)
+		if err != nil {
+			slog.Warn("Storing feed metadata failed", "feed_url", url, "error", err)
+		}
+	}
+
+	// Заголовки запоминаются только после сохранения публикаций,
+	// иначе прерванная запись не повторится из-за ответа 304
+	feedCacheMu.Lock()
+	feedCache[url] = cacheHeaders{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	feedCacheMu.Unlock()
+	// Повторами считаются и уже сохранённые публикации,
+	// и повторы внутри самого документа ленты
+	slog.Info("Feed fetched", "feed_url", url, "status", resp.StatusCode, "items", fetched,
+		"new", len(added), "dup", fetched-muted-skipped-len(added), "muted", muted, "skipped", skipped)
+	return nil
+}
+
+// Выполнение запроса с повторами при сетевых ошибках и ответах 5xx.
+// Ожидание между попытками прерывается отменой контекста.
+func (p retryPolicy) do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	delay := p.BaseDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		retryable := (err != nil && !errors.Is(err, errRedirectRefused)) || (err == nil && resp.StatusCode >= 500)
+		if !retryable || attempt >= p.Attempts {
+			return resp, err
+		}
+
+		if err != nil {
+			slog.Warn("Fetch attempt failed", "feed_url", req.URL.String(), "attempt", attempt, "error", err)
+		} else {
+			slog.Warn("Fetch attempt failed", "feed_url", req.URL.String(), "attempt", attempt, "status", resp.StatusCode)
+			resp.Body.Close()
+		}
+
+		wait := delay
+		if delay > 0 {
+			wait += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// Ошибка для ответов, которые вовсе не являются лентой
+var errNotAFeed = errors.New("not a feed")
+
+// Быстрая проверка начала ответа: лента — это XML, а не HTML,
+// JSON или текст. Понятная ошибка попадает в состояние ленты
+// вместо невнятной ошибки XML-парсера.
+func sniffFeed(body []byte) error {
+	head := bytes.TrimLeft(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(head) == 0 {
+		return fmt.Errorf("%w: empty response", errNotAFeed)
+	}
+	switch head[0] {
+	case '<':
+	case '{', '[':
+		return fmt.Errorf("%w: response looks like JSON", errNotAFeed)
+	default:
+		return fmt.Errorf("%w: response is not XML", errNotAFeed)
 	}
 
-	var rss RSS
-	err = xml.Unmarshal(body, &rss)
+	if len(head) > 64 {
+		head = head[:64]
+	}
+	head = bytes.ToLower(head)
+	if bytes.HasPrefix(head, []byte("<!doctype html")) || bytes.HasPrefix(head, []byte("<html")) {
+		return fmt.Errorf("%w: response is an HTML page", errNotAFeed)
+	}
+	return nil
+}
+
+// Разбор ленты: формат определяется по корневому элементу.
+// Кодировка из объявления <?xml encoding=...?> перекодируется в UTF-8.
+func parseFeed(body []byte) (FeedMeta, []Item, error) {
+	err := sniffFeed(body)
 	if err != nil {
-		log.Printf("Error unmarshalling XML: %v", err)
-		return
+		return FeedMeta{}, nil, err
 	}
 
-	for _, item := range rss.Channel.Items {
-		insertItem(item)
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return FeedMeta{}, nil, err
+		}
+
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch root.Name.Local {
+		case "rss":
+			return parseRSS(decoder)
+		case "feed":
+			return parseAtom(decoder)
+		case "html":
+			return FeedMeta{}, nil, fmt.Errorf("%w: response is an HTML page", errNotAFeed)
+		default:
+			return FeedMeta{}, nil, fmt.Errorf("unsupported feed format <%s>", root.Name.Local)
+		}
 	}
 }
 
-// Чтение конфигурационного файла
+// Потоковый разбор RSS, как и Atom: при ошибке в середине документа
+// возвращаются item, прочитанные до неё. Из прочих дочерних элементов
+// channel читаются заголовок, описание, ссылка и подсказки об обновлении.
+func parseRSS(decoder *xml.Decoder) (FeedMeta, []Item, error) {
+	var meta FeedMeta
+	var items []Item
+	var ttl, updatePeriod, updateFrequency string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return meta, items, nil
+		}
+		if err != nil {
+			return meta, items, err
+		}
+
+		// Закрывающий тег channel или самого rss
+		if _, ok := token.(xml.EndElement); ok {
+			return meta, items, nil
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case start.Name.Local == "channel":
+			// Дочерние элементы channel читаются этим же циклом
+			continue
+		case start.Name.Local == "item":
+			var item Item
+			err = decoder.DecodeElement(&item, &start)
+			if err != nil {
+				return meta, items, err
+			}
+			if item.Link == "" && item.GUID.PermaLink() {
+				item.Link = strings.TrimSpace(item.GUID.Value)
+			}
+			item.Author = item.author()
+			if strings.TrimSpace(item.PubDate) == "" {
+				item.PubDate = strings.TrimSpace(item.DCDate)
+			}
+			items = append(items, item)
+		case isChannelField(start.Name):
+			var value string
+			err = decoder.DecodeElement(&value, &start)
+			if err != nil {
+				return meta, items, err
+			}
+			value = strings.TrimSpace(value)
+			switch start.Name.Local {
+			case "title":
+				meta.Title = value
+			case "description":
+				meta.Description = value
+			case "link":
+				if meta.Link == "" {
+					meta.Link = value
+				}
+			case "ttl":
+				ttl = value
+			case "updatePeriod":
+				updatePeriod = value
+			case "updateFrequency":
+				updateFrequency = value
+			}
+			meta.UpdateEvery = updateInterval(ttl, updatePeriod, updateFrequency)
+		default:
+			// В том числе itunes:title и atom:link, одноимённые элементам RSS
+			err = decoder.Skip()
+			if err != nil {
+				return meta, items, err
+			}
+		}
+	}
+}
+
+// Элементы channel, которые попадают в FeedMeta: элементы RSS
+// и подсказки модуля Syndication
+func isChannelField(name xml.Name) bool {
+	switch name.Space {
+	case "":
+		return name.Local == "title" || name.Local == "description" || name.Local == "link" || name.Local == "ttl"
+	case syndicationNS:
+		return name.Local == "updatePeriod" || name.Local == "updateFrequency"
+	}
+	return false
+}
+
+// Потоковый разбор Atom: при ошибке в середине документа
+// возвращаются entry, прочитанные до неё. Из прочих дочерних
+// элементов feed читаются заголовок, подзаголовок и ссылка.
+func parseAtom(decoder *xml.Decoder) (FeedMeta, []Item, error) {
+	var meta FeedMeta
+	var items []Item
+	var updatePeriod, updateFrequency string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return meta, items, nil
+		}
+		if err != nil {
+			return meta, items, err
+		}
+
+		// Вложенные элементы читаются целиком, поэтому
+		// закрывающий тег здесь может быть только у самого feed
+		if _, ok := token.(xml.EndElement); ok {
+			return meta, items, nil
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "entry":
+			var entry AtomEntry
+			err = decoder.DecodeElement(&entry, &start)
+			if err != nil {
+				return meta, items, err
+			}
+			items = append(items, entry.Item())
+		case "title", "subtitle":
+			var text AtomText
+			err = decoder.DecodeElement(&text, &start)
+			if err != nil {
+				return meta, items, err
+			}
+			if start.Name.Local == "title" {
+				meta.Title = strings.TrimSpace(text.String())
+			} else {
+				meta.Description = strings.TrimSpace(text.String())
+			}
+		case "link":
+			var link AtomLink
+			err = decoder.DecodeElement(&link, &start)
+			if err != nil {
+				return meta, items, err
+			}
+			if meta.Link == "" && (link.Rel == "" || link.Rel == "alternate") {
+				meta.Link = link.Href
+			}
+		case "updatePeriod", "updateFrequency":
+			var value string
+			err = decoder.DecodeElement(&value, &start)
+			if err != nil {
+				return meta, items, err
+			}
+			if start.Name.Space != syndicationNS {
+				continue
+			}
+			if start.Name.Local == "updatePeriod" {
+				updatePeriod = value
+			} else {
+				updateFrequency = value
+			}
+			meta.UpdateEvery = updateInterval("", updatePeriod, updateFrequency)
+		default:
+			err = decoder.Skip()
+			if err != nil {
+				return meta, items, err
+			}
+		}
+	}
+}
+
+// Чтение конфигурационного файла. Пустой файл означает значения
+// по умолчанию; если файла нет, возвращаются они же вместе с ошибкой
+// os.ErrNotExist, и вызывающий решает, продолжать ли работу.
 func readConfig(filename string) (Config, error) {
-	var config Config
+	config := Config{
+		Period:           30,
+		ShutdownTimeout:  10,
+		FetchTimeout:     15,
+		DBTimeout:        5,
+		RetryAttempts:    3,
+		RetryDelay:       1,
+		UserAgent:        defaultUserAgent,
+		LogLevel:         "info",
+		MaxConcurrency:   10,
+		Driver:           "sqlite",
+		DBPath:           "./rss.db",
+		AllowedOrigins:   []string{"*"},
+		Listen:           ":8080",
+		BreakerThreshold: 5,
+		BreakerCooldown:  5,
+		MaxRedirects:     5,
+		MaxBodyMB:        10,
+		Chat:             ChatConfig{RateLimit: 10},
+		UntitledItems:    "keep",
+		SnippetMarkers:   snippetMarkers,
+		HostDelay:        1,
+		RateLimit:        10,
+		RateBurst:        20,
+	}
 	configFile, err := os.Open(filename)
 	if err != nil {
 		return config, err
@@ -110,75 +1078,598 @@ func readConfig(filename string) (Config, error) {
 
 	decoder := json.NewDecoder(configFile)
 	err = decoder.Decode(&config)
+	if err == io.EOF {
+		return config, nil
+	}
 	return config, err
 }
 
-// Периодическая проверка RSS
-func pollFeeds(config Config) {
-	ticker := time.NewTicker(time.Duration(config.Period) * time.Minute)
-	for range ticker.C {
-		var wg sync.WaitGroup
-		for _, url := range config.Feeds {
-			wg.Add(1)
-			go fetchRSS(url, &wg)
+// Проверка конфигурации до запуска. Возвращает ошибку
+// со списком всех найденных проблем, а не только первой.
+func validateConfig(config Config) error {
+	var errs []error
+	if config.Period <= 0 {
+		errs = append(errs, fmt.Errorf("period must be positive, got %d", config.Period))
+	}
+	if config.FetchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("fetch_timeout must be positive, got %d", config.FetchTimeout))
+	}
+	if config.DBTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("db_timeout must be positive, got %d", config.DBTimeout))
+	}
+	if config.RetryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("retry_attempts must be at least 1, got %d", config.RetryAttempts))
+	}
+	if config.RetentionDays < 0 {
+		errs = append(errs, fmt.Errorf("retention_days must not be negative, got %d", config.RetentionDays))
+	}
+	if config.MaxItems < 0 {
+		errs = append(errs, fmt.Errorf("max_items must not be negative, got %d", config.MaxItems))
+	}
+	if config.BreakerThreshold < 0 {
+		errs = append(errs, fmt.Errorf("breaker_threshold must not be negative, got %d", config.BreakerThreshold))
+	}
+	if config.BreakerThreshold > 0 && config.BreakerCooldown <= 0 {
+		errs = append(errs, fmt.Errorf("breaker_cooldown must be positive, got %d", config.BreakerCooldown))
+	}
+	if config.MaxBodyMB <= 0 {
+		errs = append(errs, fmt.Errorf("max_body_mb must be positive, got %d", config.MaxBodyMB))
+	}
+	if config.WebhookURL != "" {
+		if err := validateFeedURL(config.WebhookURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid webhook_url: %w", err))
+		}
+	}
+	switch config.UntitledItems {
+	case "keep", "skip", "placeholder":
+	default:
+		errs = append(errs, fmt.Errorf("untitled_items must be \"keep\", \"skip\" or \"placeholder\", got %q", config.UntitledItems))
+	}
+	if err := validateChat(config.Chat); err != nil {
+		errs = append(errs, err)
+	}
+	if config.PreviewLength < 0 {
+		errs = append(errs, fmt.Errorf("preview_length must not be negative, got %d", config.PreviewLength))
+	}
+	if config.HostDelay < 0 {
+		errs = append(errs, fmt.Errorf("host_delay must not be negative, got %d", config.HostDelay))
+	}
+	if config.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit must not be negative, got %d", config.RateLimit))
+	}
+	if config.RateLimit > 0 && config.RateBurst < 1 {
+		errs = append(errs, fmt.Errorf("rate_burst must be at least 1, got %d", config.RateBurst))
+	}
+	if config.MaxRedirects < 0 {
+		errs = append(errs, fmt.Errorf("max_redirects must not be negative, got %d", config.MaxRedirects))
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		errs = append(errs, fmt.Errorf("tls_cert and tls_key must be set together"))
+	}
+	if config.Proxy != "" {
+		if _, err := parseProxyURL(config.Proxy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid proxy %q: %w", config.Proxy, err))
 		}
-		wg.Wait()
 	}
+
+	seen := map[string]bool{}
+	for i, feed := range config.Feeds {
+		err := validateFeedURL(feed.URL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("feeds[%d]: invalid URL %q: %w", i, feed.URL, err))
+		}
+		if seen[feed.URL] {
+			errs = append(errs, fmt.Errorf("feeds[%d]: duplicate URL %q", i, feed.URL))
+		}
+		seen[feed.URL] = true
+		if feed.Period < 0 {
+			errs = append(errs, fmt.Errorf("feeds[%d]: period must not be negative, got %d", i, feed.Period))
+		}
+		if feed.Proxy != "" {
+			if _, err := parseProxyURL(feed.Proxy); err != nil {
+				errs = append(errs, fmt.Errorf("feeds[%d]: invalid proxy %q: %w", i, feed.Proxy, err))
+			}
+		}
+		if err := validateHeaders(feed.Headers); err != nil {
+			errs = append(errs, fmt.Errorf("feeds[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// API для получения публикаций
-func apiHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	count, err := strconv.Atoi(vars["count"])
+// Повторное чтение конфигурации без перезапуска. Применяются список
+// лент, период опроса и уровень журнала; остальные параметры
+// вступают в силу только после перезапуска.
+func reloadConfig(path string) error {
+	config, err := readConfig(path)
 	if err != nil {
-		http.Error(w, "Invalid count parameter", http.StatusBadRequest)
-		return
+		return err
 	}
+	fileConfig := config
 
-	rows, err := db.Query(`SELECT title, description, link, pubDate FROM rss ORDER BY pubDate DESC LIMIT ?`, count)
+	err = applyEnv(&config)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
+	}
+	err = validateConfig(config)
+	if err != nil {
+		return err
+	}
+
+	var level slog.Level
+	err = level.UnmarshalText([]byte(config.LogLevel))
+	if err != nil {
+		return err
+	}
+
+	feeds.Reload(fileConfig, config.Period)
+	logLevel.Set(level)
+	return nil
+}
+
+// Переменные окружения, переопределяющие строковые поля конфигурации
+func envStrings(config *Config) map[string]*string {
+	return map[string]*string{
+		"NEWS_DRIVER":      &config.Driver,
+		"NEWS_DB_PATH":     &config.DBPath,
+		"NEWS_LISTEN":      &config.Listen,
+		"NEWS_LOG_LEVEL":   &config.LogLevel,
+		"NEWS_USER_AGENT":  &config.UserAgent,
+		"NEWS_API_KEY":     &config.APIKey,
+		"NEWS_TLS_CERT":    &config.TLSCert,
+		"NEWS_TLS_KEY":     &config.TLSKey,
+		"NEWS_PROXY":       &config.Proxy,
+		"NEWS_WEBHOOK_URL": &config.WebhookURL,
+	}
+}
+
+// Переменные окружения, переопределяющие числовые поля конфигурации
+func envInts(config *Config) map[string]*int {
+	return map[string]*int{
+		"NEWS_PERIOD":           &config.Period,
+		"NEWS_FETCH_TIMEOUT":    &config.FetchTimeout,
+		"NEWS_DB_TIMEOUT":       &config.DBTimeout,
+		"NEWS_HOST_DELAY":       &config.HostDelay,
+		"NEWS_RATE_LIMIT":       &config.RateLimit,
+		"NEWS_RATE_BURST":       &config.RateBurst,
+		"NEWS_SHUTDOWN_TIMEOUT": &config.ShutdownTimeout,
+		"NEWS_RETRY_ATTEMPTS":   &config.RetryAttempts,
+		"NEWS_MAX_CONCURRENCY":  &config.MaxConcurrency,
+		"NEWS_RETENTION_DAYS":   &config.RetentionDays,
+		"NEWS_MAX_ITEMS":        &config.MaxItems,
+		"NEWS_MAX_REDIRECTS":    &config.MaxRedirects,
+		"NEWS_MAX_BODY_MB":      &config.MaxBodyMB,
+	}
+}
+
+// Переопределение конфигурации заданными переменными окружения.
+// NEWS_ALLOWED_ORIGINS — список источников через запятую.
+// DB_PATH поддерживается для совместимости, NEWS_DB_PATH важнее.
+func applyEnv(config *Config) error {
+	if path := os.Getenv("DB_PATH"); path != "" {
+		config.DBPath = path
+	}
+
+	for name, field := range envStrings(config) {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
 	}
-	defer rows.Close()
 
-	items := []Item{}
-	for rows.Next() {
-		var item Item
-		err := rows.Scan(&item.Title, &item.Description, &item.Link, &item.PubDate)
+	for name, field := range envInts(config) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = n
+	}
+
+	if value, ok := os.LookupEnv("NEWS_ALLOWED_ORIGINS"); ok {
+		config.AllowedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				config.AllowedOrigins = append(config.AllowedOrigins, origin)
+			}
+		}
+	}
+	return nil
+}
+
+// Как часто планировщик проверяет, не пора ли опросить ленты
+const schedulerTick = 10 * time.Second
+
+// Периодическая проверка RSS до отмены контекста.
+// Каждая лента опрашивается со своим периодом, список лент
+// перечитывается на каждом такте планировщика. Новые ленты,
+// в том числе все ленты при запуске, опрашиваются сразу.
+// Одновременно выполняется не более maxConcurrency загрузок.
+// Отмена контекста прерывает начатые загрузки и запись в базу.
+// По окончании каждого прохода в журнал пишется его итог.
+func pollFeeds(ctx context.Context, maxConcurrency int) {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	// Загрузки всех проходов делят один предел параллельности
+	var fetches, rounds errgroup.Group
+	fetches.SetLimit(maxConcurrency)
+	defer fetches.Wait()
+	defer rounds.Wait()
+
+	var mu sync.Mutex
+	running := map[string]bool{}
+	next := map[string]time.Time{}
+	done := func(feed Feed) {
+		mu.Lock()
+		delete(running, feed.URL)
+		mu.Unlock()
+	}
+
+	poll := func(now time.Time) {
+		list, period := feeds.Schedule()
+		active := map[string]bool{}
+		var due []Feed
+		for _, feed := range list {
+			active[feed.URL] = true
+
+			// Отключённая лента ждёт окончания паузы
+			if feedStatuses.get(feed.URL).open(now) {
+				continue
+			}
+
+			mu.Lock()
+			busy := running[feed.URL]
+			if now.Before(next[feed.URL]) || busy {
+				mu.Unlock()
+				continue
+			}
+			running[feed.URL] = true
+			mu.Unlock()
+
+			next[feed.URL] = now.Add(feedStatuses.get(feed.URL).interval(feed.Interval(period)))
+			due = append(due, feed)
+		}
+
+		if len(due) > 0 {
+			rounds.Go(func() error {
+				errs := pollRound(ctx, &fetches, due, done)
+				slog.Info("Poll finished", "total", len(due), "succeeded", len(due)-len(errs), "failed", len(errs))
+				return errors.Join(errs...)
+			})
+		}
+
+		// Забываем расписание удалённых лент
+		for feedURL := range next {
+			if !active[feedURL] {
+				delete(next, feedURL)
+			}
+		}
+	}
+
+	poll(time.Now())
+
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case now := <-ticker.C:
+			poll(now)
+		}
+	}
+}
+
+// Загрузка лент одного прохода планировщика; done вызывается
+// по окончании каждой. Ленты одного хоста загружаются по очереди
+// в одной задаче, разные хосты — параллельно. Возвращает ошибки
+// неудавшихся загрузок, в том числе не начатых из-за отмены контекста.
+func pollRound(ctx context.Context, fetches *errgroup.Group, due []Feed, done func(Feed)) []error {
+	var hosts []string
+	byHost := map[string][]Feed{}
+	for _, feed := range due {
+		host := feedHost(feed.URL)
+		if byHost[host] == nil {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], feed)
+	}
+
+	results := make(chan error, len(due))
+	for _, host := range hosts {
+		host, list := host, byHost[host]
+		if ctx.Err() != nil {
+			for _, feed := range list {
+				done(feed)
+				results <- ctx.Err()
+			}
+			continue
 		}
-		items = append(items, item)
+		fetches.Go(func() error {
+			var errs []error
+			for _, feed := range list {
+				err := fetchFromHost(ctx, host, feed)
+				done(feed)
+				results <- err
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+	var errs []error
+	for range due {
+		if err := <-results; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Загрузка ленты в очереди её хоста
+func fetchFromHost(ctx context.Context, host string, feed Feed) error {
+	release, err := feedHosts.acquire(ctx, host)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fetchRSS(ctx, feed)
+}
+
+// Перенаправление запросов на тот же адрес по HTTPS
+// на порт сервера, слушающего addr
+func redirectToHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// Уровень журнала, задаваемый конфигурацией
+var logLevel = new(slog.LevelVar)
+
+// Запись ошибки в журнал и завершение процесса
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
+	configPath := flag.String("config", "config.json", "path to the config file")
+	listen := flag.String("listen", "", "HTTP listen address, overrides listen from the config file")
+	dbPath := flag.String("db", "", "database path or connection string, overrides db_path and DB_PATH")
+	flag.Parse()
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
 	// Чтение конфигурационного файла
-	config, err := readConfig("config.json")
+	config, err := readConfig(*configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Config file not found, using defaults", "path", *configPath)
+	} else if err != nil {
+		fatal("Error reading config file", "path", *configPath, "error", err)
+	}
+
+	// Список лент сохраняет конфигурацию из файла, чтобы
+	// переопределения ниже не попали в файл при его записи
+	fileConfig := config
+
+	// Переопределения: флаги важнее переменных окружения, а те — файла
+	err = applyEnv(&config)
+	if err != nil {
+		fatal("Invalid environment override", "error", err)
+	}
+	if *listen != "" {
+		config.Listen = *listen
+	}
+	if *dbPath != "" {
+		config.DBPath = *dbPath
+	}
+
+	err = validateConfig(config)
+	if err != nil {
+		fatal("Invalid config", "path", *configPath, "error", err)
+	}
+	if len(config.Feeds) == 0 {
+		slog.Warn("No feeds configured", "path", *configPath)
+	}
+	feeds = newFeedManager(*configPath, fileConfig, config.Period)
+
+	err = logLevel.UnmarshalText([]byte(config.LogLevel))
 	if err != nil {
-		log.Fatalf("Error reading config file: %v", err)
+		fatal("Invalid log level", "log_level", config.LogLevel, "error", err)
 	}
 
+	httpClient.Timeout = time.Duration(config.FetchTimeout) * time.Second
+	dbTimeout = time.Duration(config.DBTimeout) * time.Second
+	userAgent = config.UserAgent
+	if config.Proxy != "" {
+		proxyURL, _ = parseProxyURL(config.Proxy)
+	}
+	sanitizeHTML = config.SanitizeHTML
+	webhookURL = config.WebhookURL
+	chat = &chatNotifier{config: config.Chat}
+	untitledItems = config.UntitledItems
+	previewLength = config.PreviewLength
+	snippetMarkers = config.SnippetMarkers
+	muteFilter = newMuteRules(config.MuteKeywords, config.BlockDomains)
+	fetchRetry = retryPolicy{
+		Attempts:  config.RetryAttempts,
+		BaseDelay: time.Duration(config.RetryDelay) * time.Second,
+	}
+	maxBodySize = int64(config.MaxBodyMB) << 20
+	feedHosts = newHostQueue(time.Duration(config.HostDelay) * time.Second)
+	feedRedirects = redirectPolicy{Max: config.MaxRedirects, SameHost: config.SameHostRedirects}
+	feedBreaker.Threshold = config.BreakerThreshold
+	feedBreaker.Cooldown = time.Duration(config.BreakerCooldown) * time.Minute
+
 	// Инициализация базы данных
-	initDB()
-	defer db.Close()
+	store, err = openStorage(config.Driver, config.DBPath)
+	if err != nil {
+		fatal("Error initializing database", "error", err)
+	}
+	defer store.Close()
 
-	// Запуск периодического обхода RSS-лент
-	go pollFeeds(config)
+	err = loadFeedStates(context.Background())
+	if err != nil {
+		slog.Warn("Loading feed state failed", "error", err)
+	}
+
+	// Остановка по Ctrl-C и SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Перечитывание конфигурации по SIGHUP; планировщик берёт
+	// список лент у feeds на каждом такте, поэтому изменения
+	// подхватываются без остановки опроса
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := reloadConfig(*configPath)
+			if err != nil {
+				slog.Error("Config reload failed", "path", *configPath, "error", err)
+				continue
+			}
+			slog.Info("Config reloaded", "path", *configPath, "feeds", len(feeds.List()))
+		}
+	}()
+
+	// Запуск периодического обхода RSS-лент, удаления устаревших
+	// публикаций и отправки уведомлений о новых
+	var background sync.WaitGroup
+	background.Add(4)
+	go func() {
+		defer background.Done()
+		pollFeeds(ctx, config.MaxConcurrency)
+	}()
+	go func() {
+		defer background.Done()
+		runMaintenance(ctx, retentionPolicy{
+			MaxAge:   time.Duration(config.RetentionDays) * 24 * time.Hour,
+			MaxItems: config.MaxItems,
+		})
+	}()
+	go func() {
+		defer background.Done()
+		webhookQueue.run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		chatQueue.run(ctx)
+	}()
+	backgroundDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(backgroundDone)
+	}()
 
 	// Настройка маршрутов HTTP
-	r := mux.NewRouter()
-	r.HandleFunc("/api/news/{count}", apiHandler).Methods("GET")
+	// Адрес ленты в пути передаётся экранированным, поэтому маршруты
+	// сопоставляются с закодированным путём
+	r := mux.NewRouter().UseEncodedPath()
+	origins := newOriginPolicy(config.AllowedOrigins)
+	wsUpgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || origins.allows(origin)
+	}
+
+	// Маршруты API. CORS и проверка ключа оборачивают весь роутер API,
+	// чтобы их проходили и предварительные запросы OPTIONS, и ответы 404/405
+	api := mux.NewRouter().UseEncodedPath()
+	api.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	api.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	v1 := api.PathPrefix("/api/v1").Subrouter()
+	v1.HandleFunc("/news/item/{id}", itemHandler).Methods("GET")
+	v1.HandleFunc("/news/item/{id}/read", readHandler(true)).Methods("POST")
+	v1.HandleFunc("/news/item/{id}/unread", readHandler(false)).Methods("POST")
+	v1.HandleFunc("/news/item/{id}/star", starHandler(true)).Methods("POST")
+	v1.HandleFunc("/news/item/{id}/unstar", starHandler(false)).Methods("POST")
+	v1.HandleFunc("/news/starred", starredHandler).Methods("GET")
+	v1.HandleFunc("/news/count", countHandler).Methods("GET")
+	v1.HandleFunc("/news/{count}", apiHandler).Methods("GET")
+	v1.HandleFunc("/search", searchHandler).Methods("GET")
+	v1.HandleFunc("/feed.xml", feedXMLHandler).Methods("GET")
+	v1.HandleFunc("/discover", discoverHandler).Methods("GET")
+	v1.HandleFunc("/categories", categoriesHandler).Methods("GET")
+	v1.HandleFunc("/status", statusHandler).Methods("GET")
+	v1.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+	v1.HandleFunc("/feeds", listFeedsHandler).Methods("GET")
+	v1.HandleFunc("/feeds/health", feedHealthHandler).Methods("GET")
+	v1.HandleFunc("/feeds", addFeedHandler).Methods("POST")
+	v1.HandleFunc("/feeds/import", importOPMLHandler).Methods("POST")
+	v1.HandleFunc("/feeds/export.opml", exportOPMLHandler).Methods("GET")
+	v1.HandleFunc("/feeds/{url:.+}", deleteFeedHandler).Methods("DELETE")
+	// Адрес до появления версий API, оставлен для старых клиентов
+	api.HandleFunc("/api/news/{count}", deprecated(apiHandler)).Methods("GET")
+	v1.HandleFunc("/ws", wsHandler).Methods("GET")
+	limit := rateLimitMiddleware(config.RateLimit, config.RateBurst)
+	r.PathPrefix("/api/").Handler(gzipMiddleware(corsMiddleware(origins)(limit(apiKeyMiddleware(config.APIKey)(etagMiddleware(api))))))
+
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET")
 
 	// Настройка статических файлов
 	fs := http.FileServer(http.Dir("./static"))
 	r.PathPrefix("/").Handler(fs)
 
 	// Запуск сервера
-	log.Fatal(http.ListenAndServe(":8080", r))
+	srv := &http.Server{Addr: config.Listen, Handler: logRequests(recoverMiddleware(r))}
+	srv.RegisterOnShutdown(hub.closeAll)
+	useTLS := config.TLSCert != "" && config.TLSKey != ""
+	go func() {
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("HTTP server failed", "error", err)
+		}
+	}()
+
+	var redirectSrv *http.Server
+	if useTLS && config.HTTPRedirect != "" {
+		redirectSrv = &http.Server{Addr: config.HTTPRedirect, Handler: redirectToHTTPS(srv.Addr)}
+		go func() {
+			err := redirectSrv.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				fatal("HTTP redirect server failed", "error", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	slog.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error("Error shutting down HTTP server", "error", err)
+	}
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(shutdownCtx)
+	}
+
+	// Ожидание прерванных загрузок, чтобы не закрыть базу посреди записи
+	select {
+	case <-backgroundDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("Timed out waiting for background jobs to finish")
+	}
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Хранилище в памяти вместо глобального store на время теста
+func useMemoryStore(t *testing.T) Storage {
+	t.Helper()
+	s, err := newSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatalf("opening storage: %v", err)
+	}
+	previous := store
+	store = s
+	t.Cleanup(func() {
+		store = previous
+		s.Close()
+	})
+	return s
+}
+
+// Сервер, отдающий файл из testdata по любому адресу
+func serveFixture(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Публикации в хранилище в порядке добавления
+func storedItems(t *testing.T, s Storage) []Item {
+	t.Helper()
+	items, _, err := s.ListItems(context.Background(), ItemQuery{Limit: 100, Sort: "id_asc"})
+	if err != nil {
+		t.Fatalf("listing items: %v", err)
+	}
+	return items
+}
+
+func TestFetchFeed(t *testing.T) {
+	type row struct {
+		Title, Link, PubDate string
+	}
+	tests := []struct {
+		name    string
+		fixture string
+		wantErr bool
+		// Ошибка разбора записана в состояние ленты без неудачи загрузки
+		broken bool
+		want   []row
+	}{
+		{
+			name:    "rss",
+			fixture: "rss.xml",
+			want: []row{
+				{"First story", "https://example.com/first", "2006-01-02T15:04:05Z"},
+				{"Second story", "https://example.com/second", "2006-01-03T15:04:05Z"},
+			},
+		},
+		{
+			name:    "atom",
+			fixture: "atom.xml",
+			want: []row{
+				{"Atom entry", "https://example.com/atom/1", "2006-01-02T15:04:05Z"},
+				{"Second entry", "https://example.com/atom/2", "2006-01-03T15:04:05Z"},
+			},
+		},
+		{
+			name:    "malformed xml",
+			fixture: "malformed.xml",
+			wantErr: true,
+		},
+		{
+			name:    "item after a broken one",
+			fixture: "partial.xml",
+			broken:  true,
+			want: []row{
+				{"Intact story", "https://example.com/intact", "2006-01-02T15:04:05Z"},
+			},
+		},
+		{
+			name:    "empty feed",
+			fixture: "empty.xml",
+		},
+		{
+			name:    "windows-1251",
+			fixture: "cp1251.xml",
+			want: []row{
+				{"Главная новость", "https://example.com/ru/1", "2006-01-02T12:04:05Z"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useMemoryStore(t)
+			srv := serveFixture(t, tt.fixture)
+
+			err := fetchFeed(context.Background(), srv.Client(), Feed{URL: srv.URL})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchFeed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status := feedStatuses.get(srv.URL); (status.LastError != "") != tt.broken {
+				t.Errorf("last_error = %q, broken %v", status.LastError, tt.broken)
+			}
+
+			items := storedItems(t, s)
+			if len(items) != len(tt.want) {
+				t.Fatalf("stored %d items, want %d: %+v", len(items), len(tt.want), items)
+			}
+			for i, item := range items {
+				got := row{item.Title, item.Link, item.PubDate}
+				if got != tt.want[i] {
+					t.Errorf("item %d = %+v, want %+v", i, got, tt.want[i])
+				}
+				if item.FeedURL != srv.URL {
+					t.Errorf("item %d feed_url = %q, want %q", i, item.FeedURL, srv.URL)
+				}
+			}
+		})
+	}
+}
+
+// Повторная загрузка той же ленты не добавляет публикаций
+func TestFetchFeedTwice(t *testing.T) {
+	s := useMemoryStore(t)
+	srv := serveFixture(t, "rss.xml")
+
+	for i := 0; i < 2; i++ {
+		err := fetchFeed(context.Background(), srv.Client(), Feed{URL: srv.URL})
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i+1, err)
+		}
+	}
+	if items := storedItems(t, s); len(items) != 2 {
+		t.Fatalf("stored %d items after two fetches, want 2", len(items))
+	}
+}
+
+// Ошибка разбора не сбрасывает подсказку ttl, полученную раньше
+func TestFetchFeedKeepsHintOnBrokenFeed(t *testing.T) {
+	useMemoryStore(t)
+	bodies := []string{
+		`<rss version="2.0"><channel><title>Hinted</title><ttl>30</ttl>
+			<item><title>Story</title><link>https://example.com/story</link></item></channel></rss>`,
+		`<rss version="2.0"><channel><title>Hinted</title>
+			<item><title>Intact</title><link>https://example.com/intact</link></item>
+			<item><title>Broken</title></itm></channel></rss>`,
+		`<rss version="2.0"><channel><title`,
+	}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(bodies[fetches]))
+	}))
+	t.Cleanup(srv.Close)
+
+	for fetches = range bodies {
+		fetchFeed(context.Background(), srv.Client(), Feed{URL: srv.URL})
+		if got := feedStatuses.get(srv.URL).UpdateEvery; got != 30 {
+			t.Errorf("fetch %d: update_every = %d, want 30", fetches+1, got)
+		}
+	}
+}
+
+// Одноимённые элементы itunes, media и atom не затирают элементы RSS
+func TestParseFeedNamespacedTags(t *testing.T) {
+	body, err := os.ReadFile(filepath.Join("testdata", "namespaces.xml"))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+
+	meta, items, err := parseFeed(body)
+	if err != nil {
+		t.Fatalf("parseFeed() error = %v", err)
+	}
+	if meta.Title != "Example Podcast" || meta.Link != "https://example.com/podcast" {
+		t.Errorf("meta = %+v", meta)
+	}
+	if len(items) != 1 {
+		t.Fatalf("parsed %d items, want 1", len(items))
+	}
+	item := items[0]
+	if item.Title != "Ep 12: Full title" {
+		t.Errorf("title = %q, want %q", item.Title, "Ep 12: Full title")
+	}
+	if item.Description != "Full description" {
+		t.Errorf("description = %q, want %q", item.Description, "Full description")
+	}
+	if item.Link != "https://example.com/podcast/12" {
+		t.Errorf("link = %q, want %q", item.Link, "https://example.com/podcast/12")
+	}
+	if p := item.ItunesTags.podcast(); p == nil || p.Episode != "12" {
+		t.Errorf("podcast = %+v, want episode 12", p)
+	}
+}
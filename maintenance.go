@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Как часто удаляются устаревшие и лишние публикации
+const maintenanceTick = 10 * time.Minute
+
+// Ограничения на хранимые публикации; нулевые значения их отключают
+type retentionPolicy struct {
+	// Срок хранения публикации с даты её публикации
+	MaxAge time.Duration
+	// Сколько самых свежих публикаций хранить
+	MaxItems int
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxItems > 0
+}
+
+// Периодическое удаление публикаций сверх ограничений до отмены контекста.
+// Первый проход выполняется сразу при запуске.
+func runMaintenance(ctx context.Context, policy retentionPolicy) {
+	if !policy.enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(maintenanceTick)
+	defer ticker.Stop()
+	for {
+		purge(ctx, policy)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Удаление старых публикаций, затем лишних, и сжатие базы
+func purge(ctx context.Context, policy retentionPolicy) {
+	removed := 0
+
+	if policy.MaxAge > 0 {
+		cutoff := time.Now().Add(-policy.MaxAge)
+		n, err := store.DeleteOlderThan(ctx, cutoff)
+		if err != nil {
+			slog.Error("Purging old items failed", "error", err)
+			return
+		}
+		slog.Info("Old items purged", "before", cutoff.UTC().Format(storedDateLayout), "removed", n)
+		removed += n
+	}
+
+	if policy.MaxItems > 0 {
+		n, err := store.KeepNewest(ctx, policy.MaxItems)
+		if err != nil {
+			slog.Error("Trimming items failed", "error", err)
+			return
+		}
+		slog.Info("Items over limit purged", "max_items", policy.MaxItems, "removed", n)
+		removed += n
+	}
+
+	// Освободившееся место возвращается только после VACUUM
+	if removed == 0 {
+		return
+	}
+	err := store.Vacuum(ctx)
+	if err != nil {
+		slog.Error("Vacuuming database failed", "error", err)
+	}
+}
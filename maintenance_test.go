@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Загрузка ленты, очистка и повторная загрузка той же ленты: удалённые
+// публикации не возвращаются, оставшиеся сохраняют свои id
+func fetchAroundPurge(t *testing.T, purge func(ctx context.Context, s Storage) (int, error)) (before, after []Item) {
+	t.Helper()
+	ctx := context.Background()
+	s := useMemoryStore(t)
+	srv := serveFixture(t, "rss.xml")
+
+	err := fetchFeed(ctx, srv.Client(), Feed{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	n, err := purge(ctx, s)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("purged %d items, want 1", n)
+	}
+	before = storedItems(t, s)
+
+	err = fetchFeed(ctx, srv.Client(), Feed{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	return before, storedItems(t, s)
+}
+
+func sameIDs(t *testing.T, before, after []Item) {
+	t.Helper()
+	if len(after) != len(before) {
+		t.Fatalf("stored %d items after refetch, want %d: %+v", len(after), len(before), after)
+	}
+	for i := range after {
+		if after[i].ID != before[i].ID {
+			t.Errorf("item %q id = %d after refetch, want %d", after[i].Title, after[i].ID, before[i].ID)
+		}
+	}
+}
+
+func TestDeleteOlderThanKeepsItemsPurged(t *testing.T) {
+	cutoff := time.Date(2006, 1, 3, 0, 0, 0, 0, time.UTC)
+	before, after := fetchAroundPurge(t, func(ctx context.Context, s Storage) (int, error) {
+		return s.DeleteOlderThan(ctx, cutoff)
+	})
+	sameIDs(t, before, after)
+}
+
+// Лимит меньше числа публикаций в одном документе ленты:
+// обрезанные публикации не возвращаются как новые при каждом опросе
+func TestKeepNewestKeepsItemsPurged(t *testing.T) {
+	before, after := fetchAroundPurge(t, func(ctx context.Context, s Storage) (int, error) {
+		return s.KeepNewest(ctx, 1)
+	})
+	sameIDs(t, before, after)
+	if after[0].Title != "Second story" {
+		t.Errorf("kept %q, want the newest item", after[0].Title)
+	}
+}
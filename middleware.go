@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Источники, которым разрешены запросы из браузера.
+// Пустой список или "*" разрешают любой источник.
+type originPolicy struct {
+	all     bool
+	allowed map[string]bool
+}
+
+func newOriginPolicy(origins []string) originPolicy {
+	p := originPolicy{all: len(origins) == 0, allowed: map[string]bool{}}
+	for _, origin := range origins {
+		if origin == "*" {
+			p.all = true
+		}
+		p.allowed[strings.TrimRight(origin, "/")] = true
+	}
+	return p
+}
+
+func (p originPolicy) allows(origin string) bool {
+	return p.all || p.allowed[origin]
+}
+
+// Заголовки CORS для браузерных клиентов с других доменов.
+// Предварительные запросы OPTIONS получают ответ без вызова обработчика.
+func corsMiddleware(policy originPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" {
+				if policy.all {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Add("Vary", "Origin")
+					if policy.allows(origin) {
+						w.Header().Set("Access-Control-Allow-Origin", origin)
+					}
+				}
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key")
+				w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, ETag")
+			}
+
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Проверка ключа API из заголовка X-API-Key или параметра api_key.
+// Пустой ключ отключает проверку.
+func apiKeyMiddleware(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get("X-API-Key")
+			if got == "" {
+				got = r.URL.Query().Get("api_key")
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(key)) != 1 {
+				writeJSONError(w, http.StatusUnauthorized, "Invalid or missing API key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Перехват паники в обработчике: запрос получает 500, сервер продолжает работу.
+// http.ErrAbortHandler пробрасывается дальше, им обработчик прерывает ответ намеренно.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.Error("Handler panic", "method", r.Method, "path", r.URL.Path,
+				"panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+			writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Обёртка ResponseWriter, запоминающая код и размер ответа
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Перехват соединения для WebSocket
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Доступ к исходному ResponseWriter для http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Ответы короче этого размера отдаются без сжатия: выигрыш меньше
+// заголовков gzip и затрат на сжатие
+const gzipMinSize = 1024
+
+// Сжатие ответов gzip для клиентов, принимающих его по Accept-Encoding.
+// Ответ копится в буфере до gzipMinSize байт, и только потом решается,
+// сжимать ли его. Запросы WebSocket проходят без изменений.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// Принимает ли клиент gzip; "gzip;q=0" означает отказ
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding = strings.TrimSpace(coding); coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && name == "q" {
+				q, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// ResponseWriter, сжимающий ответ, когда он набрал gzipMinSize байт
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	// Ответ идёт клиенту без сжатия
+	plain bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(b)
+	case w.plain:
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf.Write(b)
+	if w.buf.Len() < gzipMinSize {
+		return len(b), nil
+	}
+	// Обработчик сам задал кодирование — сжимать повторно нельзя
+	if w.Header().Get("Content-Encoding") != "" {
+		return len(b), w.flushPlain()
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return len(b), err
+}
+
+// Отправка накопленного ответа без сжатия
+func (w *gzipResponseWriter) flushPlain() error {
+	w.plain = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// Завершение ответа: короткий ответ уходит как есть, сжатый дописывается
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.plain {
+		return nil
+	}
+	return w.flushPlain()
+}
+
+// Доступ к исходному ResponseWriter для http.ResponseController
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Слабый ETag для ответов GET: хэш тела ответа. Клиент, приславший
+// тот же ETag в If-None-Match, получает 304 без тела. Запрос к базе
+// выполняется всё равно, экономится только передача ответа.
+func etagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &bufferedResponseWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		for key, values := range rec.header {
+			w.Header()[key] = values
+		}
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(rec.body.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Del("Content-Type")
+			w.Header().Del("X-Total-Count")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(rec.body.Bytes())
+	})
+}
+
+// Есть ли etag в списке If-None-Match; сравнение слабое, без W/
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseWriter, собирающий ответ целиком в памяти
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+// Журнал запросов: метод, путь, код и размер ответа, длительность
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		slog.Info("HTTP request", "method", r.Method, "path", r.URL.Path,
+			"status", rw.status, "size", rw.size, "duration", time.Since(start))
+	})
+}
+
+// Обёртка для адреса API без версии: предупреждение в журнале
+// и заголовки, указывающие клиенту на тот же адрес в /api/v1
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := "/api/v1" + strings.TrimPrefix(r.URL.Path, "/api")
+		slog.Warn("Deprecated API route", "path", r.URL.Path, "successor", successor)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Фильтр публикаций, отбрасываемых до записи в базу
+type muteRules struct {
+	// Слова и фразы в нижнем регистре, ищутся в заголовке и тексте
+	Keywords []string
+	// Домены в нижнем регистре; блокируются и их поддомены
+	Domains []string
+}
+
+var muteFilter muteRules
+
+func newMuteRules(keywords, domains []string) muteRules {
+	var f muteRules
+	for _, keyword := range keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+			f.Keywords = append(f.Keywords, keyword)
+		}
+	}
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			f.Domains = append(f.Domains, domain)
+		}
+	}
+	return f
+}
+
+// Подпадает ли публикация под фильтр
+func (f muteRules) matches(item Item) bool {
+	if len(f.Domains) > 0 {
+		if u, err := url.Parse(strings.TrimSpace(item.Link)); err == nil {
+			host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+			for _, domain := range f.Domains {
+				if host == domain || strings.HasSuffix(host, "."+domain) {
+					return true
+				}
+			}
+		}
+	}
+
+	if len(f.Keywords) > 0 {
+		text := strings.ToLower(item.Title + "\n" + plainText(item))
+		for _, keyword := range f.Keywords {
+			if strings.Contains(text, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Публикации, не подпадающие под фильтр
+func (f muteRules) apply(items []Item) []Item {
+	if len(f.Keywords) == 0 && len(f.Domains) == 0 {
+		return items
+	}
+	kept := items[:0]
+	for _, item := range items {
+		if !f.matches(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
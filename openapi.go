@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// Описание API в формате OpenAPI 3.0; при изменении маршрутов
+// и полей ответов его нужно обновлять вместе с кодом
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// API для получения описания самого API
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Наибольший размер загружаемого OPML-документа
+const maxOPMLSize = 5 << 20
+
+// Документ OPML со списком подписок
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    struct {
+		Title string `xml:"title"`
+	} `xml:"head"`
+	Body struct {
+		Outlines []OPMLOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+// Элемент OPML: подписка, если задан xmlUrl, иначе папка с вложенными элементами
+type OPMLOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []OPMLOutline `xml:"outline"`
+}
+
+// Ленты из всех подписок документа, включая вложенные в папки
+func (o OPML) Feeds() []Feed {
+	var list []Feed
+	var walk func(outlines []OPMLOutline)
+	walk = func(outlines []OPMLOutline) {
+		for _, outline := range outlines {
+			if feedURL := strings.TrimSpace(outline.XMLURL); feedURL != "" {
+				name := outline.Title
+				if name == "" {
+					name = outline.Text
+				}
+				list = append(list, Feed{URL: feedURL, Name: strings.TrimSpace(name)})
+			}
+			walk(outline.Outlines)
+		}
+	}
+	walk(o.Body.Outlines)
+	return list
+}
+
+// API для импорта подписок из OPML; уже известные ленты пропускаются
+func importOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	decoder := xml.NewDecoder(http.MaxBytesReader(w, r.Body, maxOPMLSize))
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	var doc OPML
+	err := decoder.Decode(&doc)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid OPML document: "+err.Error())
+		return
+	}
+
+	var valid []Feed
+	var invalid []string
+	for _, feed := range doc.Feeds() {
+		if validateFeedURL(feed.URL) != nil {
+			invalid = append(invalid, feed.URL)
+			continue
+		}
+		valid = append(valid, feed)
+	}
+
+	added, err := feeds.AddAll(valid)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Added   int      `json:"added"`
+		Present int      `json:"already_present"`
+		Invalid []string `json:"invalid,omitempty"`
+	}{added, len(valid) - added, invalid})
+}
+
+// API для экспорта подписок в OPML 2.0
+func exportOPMLHandler(w http.ResponseWriter, r *http.Request) {
+	doc := OPML{Version: "2.0"}
+	doc.Head.Title = "go_news_rss subscriptions"
+	metas := feedMetas(r)
+	for _, feed := range feeds.List() {
+		if feed.Name == "" {
+			feed.Name = metas[feed.URL].Title
+		}
+		text := feed.Name
+		if text == "" {
+			text = feed.URL
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, OPMLOutline{
+			Text:    text,
+			Title:   feed.Name,
+			Type:    "rss",
+			XMLURL:  feed.URL,
+			HTMLURL: metas[feed.URL].Link,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="feeds.opml"`)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+}
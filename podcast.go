@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Сведения о выпуске подкаста из пространства имён itunes
+type ItunesTags struct {
+	// Длительность в виде ЧЧ:ММ:СС, ММ:СС или числа секунд
+	RawDuration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration" json:"-"`
+	Image       struct {
+		Href string `xml:"href,attr"`
+	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image" json:"-"`
+	RawEpisode string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode" json:"-"`
+	RawAuthor  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author" json:"-"`
+}
+
+// Сведения о выпуске в том виде, в каком они хранятся и отдаются API
+type Podcast struct {
+	// Длительность в секундах
+	Duration int    `json:"duration,omitempty"`
+	Image    string `json:"image,omitempty"`
+	Episode  string `json:"episode,omitempty"`
+	Author   string `json:"author,omitempty"`
+}
+
+// Разобранные теги itunes; nil, если их нет
+func (t ItunesTags) podcast() *Podcast {
+	p := Podcast{
+		Duration: parseDuration(t.RawDuration),
+		Image:    strings.TrimSpace(t.Image.Href),
+		Episode:  strings.TrimSpace(t.RawEpisode),
+		Author:   strings.TrimSpace(t.RawAuthor),
+	}
+	if p == (Podcast{}) {
+		return nil
+	}
+	return &p
+}
+
+// Длительность itunes:duration в секундах; 0 — не разобрана
+func parseDuration(value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	seconds := 0
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + int(n)
+	}
+	return seconds
+}
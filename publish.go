@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Сводная лента RSS 2.0 из последних публикаций
+type rssOutput struct {
+	XMLName xml.Name      `xml:"rss"`
+	Version string        `xml:"version,attr"`
+	Channel rssOutChannel `xml:"channel"`
+}
+
+type rssOutChannel struct {
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	Description   string       `xml:"description"`
+	LastBuildDate string       `xml:"lastBuildDate,omitempty"`
+	Generator     string       `xml:"generator"`
+	Items         []rssOutItem `xml:"item"`
+}
+
+type rssOutItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link,omitempty"`
+	Description string     `xml:"description"`
+	PubDate     string     `xml:"pubDate,omitempty"`
+	GUID        *GUID      `xml:"guid,omitempty"`
+	Categories  []string   `xml:"category"`
+	Enclosure   *Enclosure `xml:"enclosure,omitempty"`
+}
+
+// Перевод публикации из базы в элемент исходящей ленты.
+// HTML описания экранируется при кодировании XML.
+func newRSSOutItem(item Item) rssOutItem {
+	out := rssOutItem{
+		Title:       item.Title,
+		Link:        item.Link,
+		Description: item.Description,
+		Categories:  item.Categories,
+	}
+	if t, err := time.Parse(storedDateLayout, item.PubDate); err == nil {
+		out.PubDate = t.Format(time.RFC1123Z)
+	}
+	// Признак isPermaLink в базе не хранится: ссылкой считается
+	// только GUID, совпадающий со ссылкой публикации
+	if item.GUID.Value != "" {
+		out.GUID = &GUID{Value: item.GUID.Value, IsPermaLink: "false"}
+		if item.GUID.Value == item.Link {
+			out.GUID.IsPermaLink = "true"
+		}
+	}
+	if item.Enclosure != nil {
+		enclosure := *item.Enclosure
+		enclosure.Length = strconv.FormatInt(enclosure.Size, 10)
+		out.Enclosure = &enclosure
+	}
+	return out
+}
+
+// API для подписки на все ленты сразу: последние публикации в виде RSS 2.0.
+// Принимает те же параметры count, feed, category, from и to, что и списки.
+func feedXMLHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := queryInt(r, "count", defaultCount)
+	if err != nil || count < 1 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid count parameter")
+		return
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+
+	var q ItemQuery
+	err = commonFilters(r, &q)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.Limit = count
+
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	items, _, err := store.ListItems(ctx, q)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	out := rssOutput{
+		Version: "2.0",
+		Channel: rssOutChannel{
+			Title:       "go_news_rss",
+			Link:        scheme + "://" + r.Host + "/",
+			Description: "Latest items from all subscribed feeds",
+			Generator:   defaultUserAgent,
+		},
+	}
+	// Дата сборки — дата самой свежей публикации, а не текущее время:
+	// иначе ответ менялся бы каждую секунду и ETag никогда не совпадал
+	var built time.Time
+	for _, item := range items {
+		out.Channel.Items = append(out.Channel.Items, newRSSOutItem(item))
+		if t, err := time.Parse(storedDateLayout, item.PubDate); err == nil && t.After(built) {
+			built = t
+		}
+	}
+	if !built.IsZero() {
+		out.Channel.LastBuildDate = built.Format(time.RFC1123Z)
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(out)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Дата сборки сводной ленты не зависит от времени запроса,
+// поэтому повторный запрос с ETag получает 304
+func TestFeedXMLLastBuildDate(t *testing.T) {
+	s := useMemoryStore(t)
+	_, err := s.InsertItems(context.Background(), []Item{
+		{Title: "Older", Link: "https://example.com/1", PubDate: "Mon, 02 Jan 2006 15:04:05 +0000"},
+		{Title: "Newer", Link: "https://example.com/2", PubDate: "Tue, 03 Jan 2006 15:04:05 +0000"},
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("inserting items: %v", err)
+	}
+	handler := etagMiddleware(http.HandlerFunc(feedXMLHandler))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/feed.xml", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET feed.xml = %d: %s", rec.Code, rec.Body)
+	}
+	var out rssOutput
+	err = xml.Unmarshal(rec.Body.Bytes(), &out)
+	if err != nil {
+		t.Fatalf("decoding feed.xml: %v", err)
+	}
+	if want := "Tue, 03 Jan 2006 15:04:05 +0000"; out.Channel.LastBuildDate != want {
+		t.Errorf("lastBuildDate = %q, want %q", out.Channel.LastBuildDate, want)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/feed.xml", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional GET feed.xml = %d, want 304", rec.Code)
+	}
+}
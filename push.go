@@ -0,0 +1,177 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Сколько публикаций ждёт отправки медленному клиенту; лишние отбрасываются
+	pushBuffer = 64
+	// Время на запись одного сообщения клиенту
+	pushWriteTimeout = 10 * time.Second
+	// Как часто клиенту отправляется ping и сколько ждать ответа
+	pushPingPeriod = 30 * time.Second
+	pushPongWait   = 2 * pushPingPeriod
+)
+
+// Подписчик на новые публикации
+type pushClient struct {
+	conn *websocket.Conn
+	send chan Item
+
+	mu sync.Mutex
+	// Ленты, на которые подписан клиент; пустой набор — все ленты
+	feeds map[string]bool
+}
+
+func (c *pushClient) wants(item Item) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.feeds) == 0 || c.feeds[item.FeedURL]
+}
+
+// Рассылка новых публикаций подключённым по WebSocket клиентам
+type pushHub struct {
+	mu      sync.Mutex
+	clients map[*pushClient]bool
+}
+
+var hub = &pushHub{clients: map[*pushClient]bool{}}
+
+// Отправка публикаций всем подписанным клиентам. Каждая публикация
+// попадает сюда один раз — сразу после её первой записи в базу.
+// Клиент, не успевающий читать, теряет публикации, но не задерживает остальных.
+func (h *pushHub) broadcast(items []Item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		for _, item := range items {
+			if !c.wants(item) {
+				continue
+			}
+			select {
+			case c.send <- item:
+			default:
+				slog.Debug("Dropping item for slow WebSocket client", "remote", c.conn.RemoteAddr().String(), "id", item.ID)
+			}
+		}
+	}
+}
+
+func (h *pushHub) add(c *pushClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = true
+}
+
+// Отключение клиента; канал send закрывается под той же блокировкой,
+// что и рассылка, поэтому запись в закрытый канал невозможна
+func (h *pushHub) remove(c *pushClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// Закрытие всех соединений при остановке сервера: Shutdown
+// не закрывает соединения, перехваченные для WebSocket
+func (h *pushHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		c.conn.Close()
+	}
+}
+
+var wsUpgrader = websocket.Upgrader{}
+
+// Сообщение клиента: {"subscribe": ["https://example.com/rss", ...]}.
+// Пустой список возвращает подписку на все ленты.
+type pushRequest struct {
+	Subscribe []string `json:"subscribe"`
+}
+
+// API для получения новых публикаций через WebSocket
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту ошибкой
+		slog.Debug("WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	c := &pushClient{conn: conn, send: make(chan Item, pushBuffer)}
+	hub.add(c)
+	go c.writeLoop()
+	c.readLoop()
+}
+
+// Чтение сообщений клиента до закрытия соединения
+func (c *pushClient) readLoop() {
+	defer func() {
+		hub.remove(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(64 << 10)
+	c.conn.SetReadDeadline(time.Now().Add(pushPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pushPongWait))
+	})
+
+	for {
+		var req pushRequest
+		err := c.conn.ReadJSON(&req)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Debug("WebSocket read failed", "error", err)
+			}
+			return
+		}
+
+		feeds := map[string]bool{}
+		for _, feedURL := range req.Subscribe {
+			feeds[feedURL] = true
+		}
+		c.mu.Lock()
+		c.feeds = feeds
+		c.mu.Unlock()
+	}
+}
+
+// Отправка публикаций и ping до закрытия канала send
+func (c *pushClient) writeLoop() {
+	ticker := time.NewTicker(pushPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case item, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			err := c.conn.WriteJSON(item)
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Клиент, не делавший запросов дольше этого, забывается вместе с лимитом
+const rateLimitIdle = 3 * time.Minute
+
+// Ограничение частоты запросов к API по IP клиента: корзина
+// токенов на perSecond запросов в секунду с запасом burst
+type ipRateLimiter struct {
+	perSecond rate.Limit
+	burst     int
+
+	mu        sync.Mutex
+	clients   map[string]*rateClient
+	lastSweep time.Time
+}
+
+type rateClient struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func newIPRateLimiter(perSecond, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		perSecond: rate.Limit(perSecond),
+		burst:     burst,
+		clients:   map[string]*rateClient{},
+	}
+}
+
+// Сколько клиенту ждать следующего запроса; 0 — запрос разрешён
+func (l *ipRateLimiter) wait(ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, client := range l.clients {
+			if now.Sub(client.seen) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	client := l.clients[ip]
+	if client == nil {
+		client = &rateClient{limiter: rate.NewLimiter(l.perSecond, l.burst)}
+		l.clients[ip] = client
+	}
+	client.seen = now
+
+	reservation := client.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// Ответ 429 с Retry-After клиентам, превысившим лимит.
+// Нулевой perSecond отключает ограничение.
+func rateLimitMiddleware(perSecond, burst int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if perSecond <= 0 {
+			return next
+		}
+		limiter := newIPRateLimiter(perSecond, burst)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if delay := limiter.wait(clientIP(r), time.Now()); delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				writeJSONError(w, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IP клиента из адреса соединения; заголовкам вроде X-Forwarded-For
+// верить нельзя, их может подставить сам клиент
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
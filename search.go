@@ -0,0 +1,185 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Метки вокруг найденных слов в отрывке результата поиска
+type SnippetMarkers struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// Метки для отрывков, см. Config.SnippetMarkers
+var snippetMarkers = SnippetMarkers{Open: "<b>", Close: "</b>"}
+
+// Временные метки FTS5 из области частного использования Unicode
+const (
+	snippetOpen  = "\uE000"
+	snippetClose = "\uE001"
+)
+
+// Приведение текста к виду для поиска: разложение NFD, удаление
+// диакритических знаков и нижний регистр, так что "Café", "CAFE"
+// и "cafe", "Ёлка" и "елка" совпадают. Так же приводится и запрос.
+func foldText(s string) string {
+	folded, _ := foldTextMap(s)
+	return folded
+}
+
+// foldText со смещениями: для каждого байта результата — смещение
+// символа исходной строки, из которого он получен, и в конце len(s).
+// По ним найденные в приведённом тексте слова отмечаются в исходном.
+func foldTextMap(s string) (string, []int) {
+	var b strings.Builder
+	offsets := make([]int, 0, len(s)+1)
+	for i, r := range s {
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			n := b.Len()
+			b.WriteRune(unicode.ToLower(d))
+			for ; n < b.Len(); n++ {
+				offsets = append(offsets, i)
+			}
+		}
+	}
+	return b.String(), append(offsets, len(s))
+}
+
+// Число слов в отрывке и слов перед первым найденным
+const (
+	snippetWords  = 16
+	snippetBefore = 4
+)
+
+// Отрывок исходного текста вокруг первого найденного слова. highlighted —
+// результат highlight() по приведённому тексту с временными метками.
+// Текст экранируется для вставки в HTML, найденные слова обрамляются
+// метками m; пустая строка — в тексте ничего не найдено.
+func highlightSnippet(original, highlighted string, m SnippetMarkers) string {
+	folded, offsets := foldTextMap(original)
+
+	// Границы найденных слов в приведённом тексте
+	var matches [][2]int
+	var plain strings.Builder
+	for h := highlighted; h != ""; {
+		open := strings.Index(h, snippetOpen)
+		if open < 0 {
+			plain.WriteString(h)
+			break
+		}
+		plain.WriteString(h[:open])
+		h = h[open+len(snippetOpen):]
+		end := strings.Index(h, snippetClose)
+		if end < 0 {
+			return ""
+		}
+		start := plain.Len()
+		plain.WriteString(h[:end])
+		h = h[end+len(snippetClose):]
+		matches = append(matches, [2]int{start, plain.Len()})
+	}
+	if len(matches) == 0 || plain.String() != folded {
+		return ""
+	}
+	for i := range matches {
+		matches[i] = [2]int{offsets[matches[i][0]], offsets[matches[i][1]]}
+	}
+
+	// Окно по словам: несколько слов до первого найденного и остальные после
+	from := matches[0][0]
+	for words := 0; from > 0; from-- {
+		if isSpace(original[from-1]) {
+			if words++; words > snippetBefore {
+				break
+			}
+		}
+	}
+	to := from
+	for words := 0; to < len(original); to++ {
+		if isSpace(original[to]) {
+			if words++; words >= snippetWords {
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, match := range matches {
+		if match[0] < pos || match[1] > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(original[pos:match[0]]))
+		b.WriteString(m.Open)
+		b.WriteString(html.EscapeString(original[match[0]:match[1]]))
+		b.WriteString(m.Close)
+		pos = match[1]
+	}
+	b.WriteString(html.EscapeString(original[pos:to]))
+	if to < len(original) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// Пробельный байт между словами текста без разметки
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+}
+
+// Запрос FTS5 из строки поиска пользователя. Слова ищутся все
+// сразу, "фраза в кавычках" — целиком, слово со звёздочкой на
+// конце — как префикс. Каждая часть берётся в кавычки, поэтому
+// операторы и знаки FTS5 в тексте запроса не вызывают ошибок.
+func ftsQuery(search string) string {
+	var terms []string
+	add := func(term string, prefix bool) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return
+		}
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	for search != "" {
+		search = strings.TrimLeft(search, " \t\r\n")
+		if strings.HasPrefix(search, `"`) {
+			end := strings.Index(search[1:], `"`)
+			if end < 0 {
+				add(search[1:], false)
+				break
+			}
+			add(search[1:end+1], false)
+			search = search[end+2:]
+			continue
+		}
+
+		end := strings.IndexAny(search, " \t\r\n\"")
+		if end < 0 {
+			end = len(search)
+		}
+		word := search[:end]
+		search = search[end:]
+		prefix := strings.HasSuffix(word, "*")
+		add(strings.TrimRight(word, "*"), prefix)
+	}
+	// Пустая фраза ничему не соответствует, а пустой запрос — ошибка FTS5
+	if len(terms) == 0 {
+		return `""`
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,937 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Хранилище публикаций
+type Storage interface {
+	// Добавление публикаций ленты одной транзакцией.
+	// При ошибке вся пачка откатывается. Возвращает новые публикации
+	// в том виде, в каком они сохранены, с присвоенными идентификаторами.
+	InsertItems(ctx context.Context, items []Item, fetchedAt time.Time) ([]Item, error)
+	// Страница публикаций по фильтру и общее число подходящих
+	ListItems(ctx context.Context, q ItemQuery) ([]Item, int, error)
+	// Число публикаций по фильтру; Limit, Offset и Sort не учитываются
+	CountItems(ctx context.Context, q ItemQuery) (int, error)
+	// Публикация по идентификатору; sql.ErrNoRows, если её нет
+	GetItem(ctx context.Context, id int) (Item, error)
+	// Отметка публикации прочитанной или непрочитанной;
+	// sql.ErrNoRows, если публикации нет
+	SetRead(ctx context.Context, id int, read bool) error
+	// Добавление публикации в избранное или удаление из него;
+	// sql.ErrNoRows, если публикации нет
+	SetStarred(ctx context.Context, id int, starred bool) error
+	// Категории с числом публикаций в каждой
+	Categories(ctx context.Context) ([]CategoryCount, error)
+	// Сохранение сведений о ленте, полученных при её загрузке
+	SaveFeedMeta(ctx context.Context, feedURL string, meta FeedMeta) error
+	// Сведения о всех лентах, загружавшихся хотя бы раз, по адресу ленты
+	FeedMetas(ctx context.Context) (map[string]FeedMeta, error)
+	// Сохранение состояния ленты после загрузки
+	SaveFeedState(ctx context.Context, feedURL string, state FeedState) error
+	// Сохранённые состояния лент по адресу ленты
+	FeedStates(ctx context.Context) (map[string]FeedState, error)
+	// Удаление публикаций, опубликованных раньше cutoff; возвращает их число.
+	// Избранные публикации здесь и в KeepNewest не удаляются, а удалённые
+	// InsertItems больше не добавляет, даже если они остались в ленте.
+	DeleteOlderThan(ctx context.Context, cutoff time.Time) (int, error)
+	// Удаление всех публикаций, кроме n самых свежих; избранные
+	// в эти n не входят. Возвращает число удалённых.
+	KeepNewest(ctx context.Context, n int) (int, error)
+	// Возврат освободившегося места файловой системе
+	Vacuum(ctx context.Context) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Фильтр и страница для выборки публикаций; пустые поля не ограничивают выборку
+type ItemQuery struct {
+	Feed     string
+	Category string
+	// Слова заголовка или текста публикации без учёта регистра и диакритики
+	Search string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+	// Автор публикации, без учёта регистра
+	Author string
+	// Только публикации с идентификатором больше этого
+	SinceID int
+	// Только непрочитанные публикации
+	Unread bool
+	// Только избранные публикации
+	Starred bool
+	// Порядок выдачи, один из ключей itemOrders; пустой — pubdate_desc
+	Sort string
+	// Метки для отрывка с найденными словами в Item.Snippet;
+	// отрывок строится только при полнотекстовом поиске
+	Snippet *SnippetMarkers
+}
+
+// Допустимые порядки выдачи публикаций. В SQL попадает только
+// значение из этого списка, а не строка из запроса.
+var itemOrders = map[string]string{
+	"pubdate_desc": "pubDate DESC",
+	"pubdate_asc":  "pubDate ASC",
+	"id_desc":      "id DESC",
+	"id_asc":       "id ASC",
+	// По релевантности BM25; без полнотекстового поиска — по дате
+	"relevance": "pubDate DESC",
+}
+
+// Категория и число публикаций в ней
+type CategoryCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+var store Storage
+
+// Открытие хранилища по имени драйвера из конфигурации
+func openStorage(driver, dsn string) (Storage, error) {
+	switch driver {
+	case "sqlite":
+		return newSQLiteStorage(dsn)
+	case "postgres":
+		return newPostgresStorage(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// Различия диалектов SQL, которые не скрывает database/sql
+type dialect struct {
+	// Плейсхолдеры $1, $2, ... вместо ?
+	numbered bool
+	// Склейка категорий публикации через символ-разделитель
+	groupConcat string
+	// Поиск по полнотекстовому индексу rss_fts (FTS5) вместо LIKE
+	fts bool
+	// Выражение, читающее rss.pubDate строкой в формате storedDateLayout
+	pubDate string
+}
+
+// Замена ? на плейсхолдеры диалекта
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Шаг миграции схемы. Номер версии шага — его позиция в списке,
+// начиная с 1; применённые шаги менять нельзя, только добавлять новые.
+type migration func(tx *sql.Tx) error
+
+// Применение шагов, которых ещё нет в schema_migrations.
+// Каждый шаг выполняется в своей транзакции вместе с записью версии.
+func migrate(db *sql.DB, d dialect, steps []migration) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(steps); i++ {
+		err := applyMigration(db, d, i+1, steps[i])
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		slog.Info("Schema migration applied", "version", i+1)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, d dialect, version int, step migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = step(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(d.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+		version, time.Now().UTC().Format(storedDateLayout))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Миграция ключей дедупликации, записанных до нормализации ссылок.
+// Если нормализованный ключ уже занят, строка остаётся как есть:
+// такие повторы сохранены раньше и удалять их миграция не берётся.
+func normalizeStoredKeys(d dialect) migration {
+	return func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id, uid FROM rss WHERE uid IS NOT NULL`)
+		if err != nil {
+			return err
+		}
+
+		keys := map[int]string{}
+		for rows.Next() {
+			var id int
+			var uid string
+			err := rows.Scan(&id, &uid)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if !isURLKey(uid) {
+				continue
+			}
+			if key := normalizeURL(uid); key != uid {
+				keys[id] = key
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, key := range keys {
+			var taken int
+			err := tx.QueryRow(d.rebind(`SELECT COUNT(*) FROM rss WHERE uid = ?`), key).Scan(&taken)
+			if err != nil {
+				return err
+			}
+			if taken > 0 {
+				continue
+			}
+			_, err = tx.Exec(d.rebind(`UPDATE rss SET uid = ? WHERE id = ?`), key, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Заполнение content_hash у сохранённых публикаций без GUID и ссылки.
+// Из нескольких одинаковых публикаций хэш получает только первая,
+// иначе уникальный индекс по content_hash не создастся.
+func fillContentHashes(tx *sql.Tx, d dialect) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(title, ''), COALESCE(pubDate, ''), COALESCE(description, '') FROM rss
+		WHERE COALESCE(guid, '') = '' AND COALESCE(link, '') = '' AND content_hash IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	hashes := map[int]string{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var id int
+		var item Item
+		err := rows.Scan(&id, &item.Title, &item.PubDate, &item.Description)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		hash := contentHash(item)
+		if !seen[hash] {
+			seen[hash] = true
+			hashes[id] = hash
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, hash := range hashes {
+		_, err := tx.Exec(d.rebind(`UPDATE rss SET content_hash = ? WHERE id = ?`), hash, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Подсчёт слов и времени чтения у публикаций, сохранённых без них
+func fillWordCounts(tx *sql.Tx, d dialect) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(plain_text, '') FROM rss WHERE word_count IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var id int
+		var text string
+		err := rows.Scan(&id, &text)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		counts[id] = wordCount(text)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, words := range counts {
+		_, err := tx.Exec(d.rebind(`UPDATE rss SET word_count = ?, reading_time = ? WHERE id = ?`), words, readingTime(words), id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Заполнение приведённого текста для поиска у сохранённых публикаций
+func fillSearchText(tx *sql.Tx, d dialect) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(title, ''), COALESCE(plain_text, '') FROM rss WHERE search_text IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	texts := map[int][2]string{}
+	for rows.Next() {
+		var id int
+		var title, text string
+		err := rows.Scan(&id, &title, &text)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		texts[id] = [2]string{foldText(title), foldText(text)}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, text := range texts {
+		_, err := tx.Exec(d.rebind(`UPDATE rss SET search_title = ?, search_text = ? WHERE id = ?`), text[0], text[1], id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Поиск главного изображения у сохранённых публикаций по их HTML;
+// элементы Media RSS в базе не хранятся и здесь не учитываются
+func fillImageURLs(tx *sql.Tx, d dialect) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(link, ''), COALESCE(description, ''), COALESCE(content, '') FROM rss WHERE image_url IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	images := map[int]string{}
+	for rows.Next() {
+		var id int
+		var item Item
+		err := rows.Scan(&id, &item.Link, &item.Description, &item.Content)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		images[id] = leadImage(item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, image := range images {
+		_, err := tx.Exec(d.rebind(`UPDATE rss SET image_url = ? WHERE id = ?`), image, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Таблица сведений о лентах; одинакова для всех хранилищ
+func createFeedsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS feeds (
+		url TEXT NOT NULL PRIMARY KEY,
+		title TEXT,
+		description TEXT,
+		link TEXT,
+		updated_at TEXT
+	)`)
+	return err
+}
+
+// Состояние лент между перезапусками: заголовки для условных
+// запросов, результаты загрузок и счётчик неудач для отключения лент
+func createFeedStateTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS feed_state (
+		url TEXT NOT NULL PRIMARY KEY,
+		etag TEXT,
+		last_modified TEXT,
+		last_fetched TEXT,
+		last_error TEXT,
+		last_error_at TEXT,
+		failures INTEGER NOT NULL DEFAULT 0,
+		open_until TEXT,
+		moved_to TEXT,
+		update_every INTEGER NOT NULL DEFAULT 0,
+		items INTEGER NOT NULL DEFAULT 0
+	)`)
+	return err
+}
+
+// Ключи дедупликации удалённых очисткой публикаций. Публикация,
+// которая ещё есть в ленте, по ним не сохраняется повторно под новым id.
+// Ключи не удаляются: строка ключа намного меньше строки публикации.
+func createPurgedKeysTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS purged_keys (
+		uid TEXT,
+		content_hash TEXT
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS purged_keys_uid_idx ON purged_keys (uid)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS purged_keys_content_hash_idx ON purged_keys (content_hash)`)
+	return err
+}
+
+// Общая реализация Storage поверх database/sql
+type sqlStorage struct {
+	db      *sql.DB
+	dialect dialect
+	// Дополнительный шаг перед вставкой каждой публикации
+	beforeInsert func(ctx context.Context, tx *sql.Tx, item Item) error
+}
+
+// Столбцы, из которых собирается Item
+func (s *sqlStorage) itemColumns() string {
+	// Имена с префиксом rss: при поиске к выборке присоединяется rss_fts
+	return `rss.id, rss.title, rss.description, rss.link, ` + s.dialect.pubDate + `, COALESCE(rss.feed_url, ''), COALESCE(rss.guid, ''), COALESCE(rss.content, ''),
+	rss.enclosure_url, COALESCE(rss.enclosure_type, ''), COALESCE(rss.enclosure_length, 0),
+	COALESCE(rss.word_count, 0), COALESCE(rss.reading_time, 0), COALESCE(rss.image_url, ''),
+	COALESCE(rss.duration, 0), COALESCE(rss.episode, ''), COALESCE(rss.itunes_image, ''), COALESCE(rss.itunes_author, ''),
+	COALESCE(rss.author, ''), rss.read, rss.starred,
+	(SELECT ` + s.dialect.groupConcat + ` FROM item_categories WHERE item_id = rss.id)`
+}
+
+// Источник строки для сканирования: *sql.Row или *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Сканирование строки, выбранной по itemColumns
+// Столбцы extra, выбранные после itemColumns, читаются в переданные адреса
+func scanItem(row rowScanner, extra ...interface{}) (Item, error) {
+	var item Item
+	var categories, enclosureURL sql.NullString
+	var enclosure Enclosure
+	var podcast Podcast
+	dest := []interface{}{&item.ID, &item.Title, &item.Description, &item.Link, &item.PubDate, &item.FeedURL, &item.GUID.Value, &item.Content,
+		&enclosureURL, &enclosure.Type, &enclosure.Size, &item.WordCount, &item.ReadingTime, &item.ImageURL,
+		&podcast.Duration, &podcast.Episode, &podcast.Image, &podcast.Author, &item.Author, &item.Read, &item.Starred, &categories}
+	err := row.Scan(append(dest, extra...)...)
+	if podcast != (Podcast{}) {
+		item.Podcast = &podcast
+	}
+	if enclosureURL.Valid {
+		enclosure.URL = enclosureURL.String
+		item.Enclosure = &enclosure
+	}
+	if categories.String != "" {
+		item.Categories = strings.Split(categories.String, "\x1f")
+	}
+	return item, err
+}
+
+func (s *sqlStorage) InsertItems(ctx context.Context, items []Item, fetchedAt time.Time) ([]Item, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.PrepareContext(ctx, s.dialect.rebind(`INSERT INTO rss
+		(uid, title, description, link, pubDate, feed_url, guid, content, enclosure_url, enclosure_type, enclosure_length, plain_text, content_hash,
+		word_count, reading_time, image_url, duration, episode, itunes_image, itunes_author, author, search_title, search_text)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING RETURNING id`))
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+
+	category, err := tx.PrepareContext(ctx, s.dialect.rebind(`INSERT INTO item_categories (item_id, name) VALUES (?, ?) ON CONFLICT DO NOTHING`))
+	if err != nil {
+		return nil, err
+	}
+	defer category.Close()
+
+	purged, err := tx.PrepareContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM purged_keys WHERE uid = ? OR content_hash = ?`))
+	if err != nil {
+		return nil, err
+	}
+	defer purged.Close()
+
+	var added []Item
+	for _, item := range items {
+		if s.beforeInsert != nil {
+			err := s.beforeInsert(ctx, tx, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stored, isNew, err := insertItem(ctx, insert, category, purged, item, fetchedAt)
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			added = append(added, stored)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// Функция для добавления публикации в базу данных.
+// Публикации без корректной даты получают время загрузки.
+// Публикации, удалённые очисткой, не добавляются снова.
+// Возвращает сохранённую публикацию и true, если она новая.
+func insertItem(ctx context.Context, insert, category, purged *sql.Stmt, item Item, fetchedAt time.Time) (Item, bool, error) {
+	key := itemKey(item)
+	// Хэш содержимого — последнее средство дедупликации, когда
+	// лента не даёт ни GUID, ни ссылки
+	var hash sql.NullString
+	if strings.TrimSpace(item.GUID.Value) == "" && item.Link == "" {
+		hash = sql.NullString{String: contentHash(item), Valid: true}
+	}
+
+	var tombstones int
+	err := purged.QueryRowContext(ctx, key, hash).Scan(&tombstones)
+	if err != nil {
+		return item, false, fmt.Errorf("checking purged keys: %w", err)
+	}
+	if tombstones > 0 {
+		return item, false, nil
+	}
+
+	pubDate, err := parsePubDate(item.PubDate)
+	if err != nil {
+		pubDate = fetchedAt
+	}
+
+	text := plainText(item)
+	item.WordCount = wordCount(text)
+	item.ReadingTime = readingTime(item.WordCount)
+	item.Podcast = item.ItunesTags.podcast()
+	var podcast Podcast
+	if item.Podcast != nil {
+		podcast = *item.Podcast
+	}
+	// Изображение ищется до очистки HTML, которая может убрать атрибуты
+	item.ImageURL = leadImage(item)
+	if sanitizeHTML {
+		item.Description = sanitize(item.Description)
+		item.Content = sanitize(item.Content)
+	}
+
+	var enclosureURL, enclosureType sql.NullString
+	var enclosureLength sql.NullInt64
+	if enclosure, ok := item.firstEnclosure(); ok {
+		enclosureURL = sql.NullString{String: enclosure.URL, Valid: true}
+		enclosureType = sql.NullString{String: enclosure.Type, Valid: true}
+		enclosureLength = sql.NullInt64{Int64: enclosure.Size, Valid: true}
+		item.Enclosure = &enclosure
+	}
+	item.PubDate = pubDate.UTC().Format(storedDateLayout)
+	item.GUID.Value = strings.TrimSpace(item.GUID.Value)
+
+	var id int64
+	err = insert.QueryRowContext(ctx, key, item.Title, item.Description, item.Link,
+		item.PubDate, item.FeedURL, item.GUID.Value, item.Content,
+		enclosureURL, enclosureType, enclosureLength, text, hash,
+		item.WordCount, item.ReadingTime, item.ImageURL,
+		nullInt(podcast.Duration), nullString(podcast.Episode), nullString(podcast.Image), nullString(podcast.Author),
+		nullString(item.Author), foldText(item.Title), foldText(text)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return item, false, nil
+	}
+	if err != nil {
+		return item, false, fmt.Errorf("inserting item %q: %w", item.Title, err)
+	}
+	item.ID = int(id)
+
+	var categories []string
+	for _, name := range item.Categories {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		categories = append(categories, name)
+		_, err := category.ExecContext(ctx, id, name)
+		if err != nil {
+			return item, false, fmt.Errorf("inserting category %q: %w", name, err)
+		}
+	}
+	item.Categories = categories
+	return item, true, nil
+}
+
+// NULL вместо пустого значения необязательного столбца
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+func nullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(storedDateLayout), Valid: true}
+}
+
+// Время, сохранённое nullTime; nil, если его нет
+func parseStoredTime(s sql.NullString) *time.Time {
+	if !s.Valid {
+		return nil
+	}
+	t, err := time.Parse(storedDateLayout, s.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// Таблицы и условие WHERE для выборки по фильтру
+func (s *sqlStorage) itemSource(q ItemQuery) (string, []interface{}) {
+	from := "rss"
+	var where []string
+	var args []interface{}
+
+	// Поиск идёт по приведённым search_title и search_text, см. foldText
+	if q.Search != "" && s.dialect.fts {
+		from = "rss JOIN rss_fts ON rss_fts.rowid = rss.id"
+		where = append(where, "rss_fts MATCH ?")
+		args = append(args, ftsQuery(foldText(q.Search)))
+	} else if q.Search != "" {
+		pattern := "%" + escapeLike(foldText(q.Search)) + "%"
+		where = append(where, `(search_title LIKE ? ESCAPE '\' OR search_text LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	if q.Feed != "" {
+		where = append(where, "feed_url = ?")
+		args = append(args, q.Feed)
+	}
+	if q.Author != "" {
+		where = append(where, "LOWER(author) = LOWER(?)")
+		args = append(args, q.Author)
+	}
+	if q.Unread {
+		where = append(where, "rss.read = ?")
+		args = append(args, false)
+	}
+	if q.Starred {
+		where = append(where, "rss.starred = ?")
+		args = append(args, true)
+	}
+	if q.SinceID > 0 {
+		where = append(where, "rss.id > ?")
+		args = append(args, q.SinceID)
+	}
+	if q.Category != "" {
+		where = append(where, "id IN (SELECT item_id FROM item_categories WHERE name = ?)")
+		args = append(args, q.Category)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "pubDate >= ?")
+		args = append(args, q.From.UTC().Format(storedDateLayout))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "pubDate <= ?")
+		args = append(args, q.To.UTC().Format(storedDateLayout))
+	}
+
+	if len(where) == 0 {
+		return from, nil
+	}
+	return from + " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *sqlStorage) ListItems(ctx context.Context, q ItemQuery) ([]Item, int, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = "pubdate_desc"
+	}
+	order, ok := itemOrders[sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort order %q", q.Sort)
+	}
+
+	if sort == "relevance" && s.dialect.fts && q.Search != "" {
+		order = "rss_fts.rank"
+	}
+
+	total, err := s.CountItems(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	columns := s.itemColumns()
+	from, args := s.itemSource(q)
+	// Слова ищутся в приведённом тексте, поэтому highlight() отмечает их
+	// там временными метками, которых нет в тексте, а отрывок собирается
+	// по исходному тексту в highlightSnippet
+	snippet := q.Snippet != nil && s.dialect.fts && q.Search != ""
+	if snippet {
+		columns += `, COALESCE(rss.plain_text, ''), highlight(rss_fts, 0, ?, ?), highlight(rss_fts, 1, ?, ?)`
+		args = append([]interface{}{snippetOpen, snippetClose, snippetOpen, snippetClose}, args...)
+	}
+	args = append(args, q.Limit, q.Offset)
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`SELECT `+columns+` FROM `+from+` ORDER BY `+order+` LIMIT ? OFFSET ?`), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := []Item{}
+	for rows.Next() {
+		var extra []interface{}
+		var text string
+		var title, body sql.NullString
+		if snippet {
+			extra = append(extra, &text, &title, &body)
+		}
+		item, err := scanItem(rows, extra...)
+		if err != nil {
+			return nil, 0, err
+		}
+		if snippet {
+			item.Snippet = highlightSnippet(text, body.String, *q.Snippet)
+			if item.Snippet == "" {
+				item.Snippet = highlightSnippet(item.Title, title.String, *q.Snippet)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
+
+func (s *sqlStorage) CountItems(ctx context.Context, q ItemQuery) (int, error) {
+	from, args := s.itemSource(q)
+
+	var total int
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM `+from), args...).Scan(&total)
+	return total, err
+}
+
+func (s *sqlStorage) GetItem(ctx context.Context, id int) (Item, error) {
+	return scanItem(s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT `+s.itemColumns()+` FROM rss WHERE id = ?`), id))
+}
+
+func (s *sqlStorage) SetRead(ctx context.Context, id int, read bool) error {
+	return s.setFlag(ctx, "read", id, read)
+}
+
+func (s *sqlStorage) SetStarred(ctx context.Context, id int, starred bool) error {
+	return s.setFlag(ctx, "starred", id, starred)
+}
+
+// Установка логического столбца публикации; column — имя из кода, не из запроса
+func (s *sqlStorage) setFlag(ctx context.Context, column string, id int, value bool) error {
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind(`UPDATE rss SET `+column+` = ? WHERE id = ?`), value, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *sqlStorage) Categories(ctx context.Context) ([]CategoryCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, COUNT(*) FROM item_categories GROUP BY name ORDER BY COUNT(*) DESC, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []CategoryCount{}
+	for rows.Next() {
+		var c CategoryCount
+		err := rows.Scan(&c.Name, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func (s *sqlStorage) SaveFeedMeta(ctx context.Context, feedURL string, meta FeedMeta) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO feeds (url, title, description, link, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET title = excluded.title, description = excluded.description,
+		link = excluded.link, updated_at = excluded.updated_at`),
+		feedURL, meta.Title, meta.Description, meta.Link, time.Now().UTC().Format(storedDateLayout))
+	return err
+}
+
+func (s *sqlStorage) FeedMetas(ctx context.Context) (map[string]FeedMeta, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT url, COALESCE(title, ''), COALESCE(description, ''), COALESCE(link, '') FROM feeds`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metas := map[string]FeedMeta{}
+	for rows.Next() {
+		var feedURL string
+		var meta FeedMeta
+		err := rows.Scan(&feedURL, &meta.Title, &meta.Description, &meta.Link)
+		if err != nil {
+			return nil, err
+		}
+		metas[feedURL] = meta
+	}
+	return metas, rows.Err()
+}
+
+func (s *sqlStorage) SaveFeedState(ctx context.Context, feedURL string, state FeedState) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO feed_state
+		(url, etag, last_modified, last_fetched, last_error, last_error_at, failures, open_until, moved_to, update_every, items)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET etag = excluded.etag, last_modified = excluded.last_modified,
+		last_fetched = excluded.last_fetched, last_error = excluded.last_error, last_error_at = excluded.last_error_at,
+		failures = excluded.failures, open_until = excluded.open_until, moved_to = excluded.moved_to,
+		update_every = excluded.update_every, items = excluded.items`),
+		feedURL, nullString(state.ETag), nullString(state.LastModified), nullTime(state.LastFetched),
+		nullString(state.LastError), nullTime(state.LastErrorAt), state.Failures, nullTime(state.OpenUntil),
+		nullString(state.MovedTo), state.UpdateEvery, state.Items)
+	return err
+}
+
+func (s *sqlStorage) FeedStates(ctx context.Context) (map[string]FeedState, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT url, COALESCE(etag, ''), COALESCE(last_modified, ''),
+		last_fetched, COALESCE(last_error, ''), last_error_at, failures, open_until,
+		COALESCE(moved_to, ''), update_every, items FROM feed_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := map[string]FeedState{}
+	for rows.Next() {
+		var feedURL string
+		var state FeedState
+		var lastFetched, lastErrorAt, openUntil sql.NullString
+		err := rows.Scan(&feedURL, &state.ETag, &state.LastModified, &lastFetched, &state.LastError, &lastErrorAt,
+			&state.Failures, &openUntil, &state.MovedTo, &state.UpdateEvery, &state.Items)
+		if err != nil {
+			return nil, err
+		}
+		state.LastFetched = parseStoredTime(lastFetched)
+		state.LastErrorAt = parseStoredTime(lastErrorAt)
+		state.OpenUntil = parseStoredTime(openUntil)
+		states[feedURL] = state
+	}
+	return states, rows.Err()
+}
+
+func (s *sqlStorage) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int, error) {
+	return s.deleteItems(ctx, `SELECT id FROM rss WHERE pubDate < ? AND starred = ?`, cutoff.UTC().Format(storedDateLayout), false)
+}
+
+func (s *sqlStorage) KeepNewest(ctx context.Context, n int) (int, error) {
+	return s.deleteItems(ctx, `SELECT id FROM rss WHERE starred = ? AND id NOT IN
+		(SELECT id FROM rss WHERE starred = ? ORDER BY pubDate DESC, id DESC LIMIT ?)`, false, false, n)
+}
+
+// Удаление публикаций, чьи id выбирает запрос selectIDs, вместе с их
+// категориями. Ключи дедупликации остаются в purged_keys.
+func (s *sqlStorage) deleteItems(ctx context.Context, selectIDs string, args ...interface{}) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO purged_keys (uid, content_hash)
+		SELECT uid, content_hash FROM rss WHERE id IN (`+selectIDs+`) ON CONFLICT DO NOTHING`), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM item_categories WHERE item_id IN (`+selectIDs+`)`), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM rss WHERE id IN (`+selectIDs+`)`), args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+func (s *sqlStorage) Vacuum(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+func (s *sqlStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+// Экранирование спецсимволов шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Хранилище в файле SQLite
+type sqliteStorage struct {
+	sqlStorage
+}
+
+// Открытие базы SQLite и приведение её схемы к текущей
+func newSQLiteStorage(dsn string) (*sqliteStorage, error) {
+	// "memory" — база в памяти для тестов и временных запусков
+	if dsn == "memory" {
+		dsn = ":memory:"
+	}
+	memory := dsn == ":memory:"
+	if !memory {
+		dsn = withSQLitePragmas(dsn)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if memory {
+		// У каждого соединения с :memory: своя пустая база, поэтому
+		// соединение одно, и оно не должно закрываться за простоем
+		// или по возрасту, иначе схема и данные пропадут
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxIdleTime(0)
+	} else {
+		// В режиме WAL читатели не ждут писателя, поэтому запросы API
+		// идут параллельно с записью при опросе лент. Писатель всё равно
+		// один: остальные ждут блокировки не дольше sqliteBusyTimeout.
+		db.SetMaxOpenConns(sqliteMaxConns)
+		db.SetMaxIdleConns(sqliteMaxConns)
+	}
+	// Соединение с локальным файлом не устаревает, пересоздавать его незачем
+	db.SetConnMaxLifetime(0)
+
+	s := &sqliteStorage{sqlStorage{
+		db:      db,
+		dialect: sqliteDialect,
+	}}
+	s.beforeInsert = s.adoptLegacyKey
+
+	err = migrate(db, s.dialect, sqliteMigrations)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Число соединений с файлом базы: одно пишет, остальные читают
+const sqliteMaxConns = 4
+
+// Сколько соединение ждёт блокировки базы, прежде чем вернуть SQLITE_BUSY
+const sqliteBusyTimeout = 5000 // мс
+
+// Параметры соединения с файлом базы, которые modernc.org/sqlite
+// применяет к каждому новому соединению:
+//   - journal_mode(WAL): чтение не блокируется записью и наоборот;
+//     режим сохраняется в самом файле базы;
+//   - busy_timeout: занятая база ожидается, а не даёт сразу
+//     "database is locked";
+//   - synchronous(NORMAL): в режиме WAL не теряет целостности при сбое,
+//     а запись не ждёт синхронизации диска на каждой транзакции;
+//   - _txlock=immediate: транзакция сразу берёт блокировку записи.
+//     Иначе транзакция, начавшаяся с чтения, получает SQLITE_BUSY при
+//     переходе к записи, и busy_timeout её не спасает.
+func withSQLitePragmas(dsn string) string {
+	params := "_pragma=journal_mode(WAL)&_pragma=busy_timeout(" + strconv.Itoa(sqliteBusyTimeout) + ")" +
+		"&_pragma=synchronous(NORMAL)&_txlock=immediate"
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params
+	}
+	return dsn + "?" + params
+}
+
+var sqliteDialect = dialect{
+	groupConcat: "group_concat(name, char(31))",
+	fts:         true,
+	// Даты хранятся строками, см. normalizeStoredDates
+	pubDate: "rss.pubDate",
+}
+
+// Миграции схемы SQLite по порядку версий
+var sqliteMigrations = []migration{
+	createSQLiteTables,
+	normalizeStoredDates,
+	fillPlainText,
+	createSQLiteIndexes,
+	normalizeStoredKeys(sqliteDialect),
+	addSQLiteContentHash,
+	createFeedsTable,
+	addSQLiteWordCount,
+	addSQLiteImageURL,
+	addSQLitePodcastColumns,
+	addSQLiteAuthor,
+	createSQLiteFTS,
+	foldSQLiteFTS,
+	createFeedStateTable,
+	addSQLiteReadState,
+	addSQLiteStarred,
+	createPurgedKeysTable,
+}
+
+// Создание таблиц. Базы, созданные до появления schema_migrations,
+// тоже проходят этот шаг: недостающие столбцы в них добавляются.
+func createSQLiteTables(tx *sql.Tx) error {
+	createTableSQL := `CREATE TABLE IF NOT EXISTS rss (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,		
+		"title" TEXT,
+		"description" TEXT,
+		"link" TEXT,
+		"pubDate" DATETIME,
+		"uid" TEXT,
+		"feed_url" TEXT,
+		"guid" TEXT,
+		"content" TEXT,
+		"enclosure_url" TEXT,
+		"enclosure_type" TEXT,
+		"enclosure_length" INTEGER,
+		"plain_text" TEXT
+	);`
+
+	_, err := tx.Exec(createTableSQL)
+	if err != nil {
+		return err
+	}
+
+	// Категории публикаций; при удалении публикаций их нужно чистить явно
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS item_categories (
+		"item_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		PRIMARY KEY (item_id, name)
+	);
+	CREATE INDEX IF NOT EXISTS item_categories_name_idx ON item_categories (name);`)
+	if err != nil {
+		return err
+	}
+
+	// Базы, созданные до появления дедупликации, не содержат столбца uid
+	for _, column := range []struct{ name, definition string }{
+		{"uid", "TEXT"},
+		{"feed_url", "TEXT"},
+		{"guid", "TEXT"},
+		{"content", "TEXT"},
+		{"enclosure_url", "TEXT"},
+		{"enclosure_type", "TEXT"},
+		{"enclosure_length", "INTEGER"},
+		{"plain_text", "TEXT"},
+	} {
+		err = ensureColumn(tx, "rss", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// В старых базах одна ссылка могла сохраниться несколько раз: ключ
+	// получает только первая такая строка, иначе индекс не создастся
+	_, err = tx.Exec(`UPDATE rss SET uid = link WHERE uid IS NULL AND link <> ''
+		AND id IN (SELECT MIN(id) FROM rss WHERE link <> '' GROUP BY link)
+		AND link NOT IN (SELECT uid FROM rss WHERE uid IS NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	// Уникальный индекс защищает и от повторной вставки между опросами,
+	// и от гонки двух горутин fetchRSS, вставляющих одну и ту же публикацию
+	_, err = tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS rss_uid_idx ON rss (uid)`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Индексы для сортировки списков по дате и поиска публикаций по ссылке.
+// Ссылка не уникальна: одну статью могут публиковать разные ленты,
+// а уникальность публикаций обеспечивает rss_uid_idx.
+func createSQLiteIndexes(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS rss_pubdate_idx ON rss (pubDate DESC);
+	CREATE INDEX IF NOT EXISTS rss_link_idx ON rss (link);`)
+	return err
+}
+
+// Столбец content_hash с уникальным индексом для публикаций без GUID и ссылки
+func addSQLiteContentHash(tx *sql.Tx) error {
+	err := ensureColumn(tx, "rss", "content_hash", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	err = fillContentHashes(tx, sqliteDialect)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS rss_content_hash_idx ON rss (content_hash)`)
+	return err
+}
+
+// Число слов и время чтения в минутах
+func addSQLiteWordCount(tx *sql.Tx) error {
+	for _, column := range []string{"word_count", "reading_time"} {
+		err := ensureColumn(tx, "rss", column, "INTEGER")
+		if err != nil {
+			return err
+		}
+	}
+	return fillWordCounts(tx, sqliteDialect)
+}
+
+// Адрес главного изображения публикации
+func addSQLiteImageURL(tx *sql.Tx) error {
+	err := ensureColumn(tx, "rss", "image_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	return fillImageURLs(tx, sqliteDialect)
+}
+
+// Столбцы для тегов itunes выпусков подкастов
+func addSQLitePodcastColumns(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"duration", "INTEGER"},
+		{"episode", "TEXT"},
+		{"itunes_image", "TEXT"},
+		{"itunes_author", "TEXT"},
+	}
+	for _, c := range columns {
+		err := ensureColumn(tx, "rss", c.name, c.definition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Автор публикации и индекс для фильтра по нему
+func addSQLiteAuthor(tx *sql.Tx) error {
+	err := ensureColumn(tx, "rss", "author", "TEXT")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS rss_author_idx ON rss (LOWER(author))`)
+	return err
+}
+
+// Отметка о прочтении публикации
+func addSQLiteReadState(tx *sql.Tx) error {
+	return ensureColumn(tx, "rss", "read", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// Отметка избранной публикации, которую не удаляет очистка
+func addSQLiteStarred(tx *sql.Tx) error {
+	return ensureColumn(tx, "rss", "starred", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// Полнотекстовый индекс по заголовку и тексту публикации. Таблица
+// rss_fts хранит только индекс, текст берётся из rss; триггеры
+// поддерживают индекс при вставке, изменении и удалении публикаций.
+func createSQLiteFTS(tx *sql.Tx) error {
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS rss_fts USING fts5(
+			title, plain_text,
+			content='rss', content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS rss_fts_insert AFTER INSERT ON rss BEGIN
+			INSERT INTO rss_fts (rowid, title, plain_text) VALUES (new.id, new.title, new.plain_text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS rss_fts_delete AFTER DELETE ON rss BEGIN
+			INSERT INTO rss_fts (rss_fts, rowid, title, plain_text) VALUES ('delete', old.id, old.title, old.plain_text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS rss_fts_update AFTER UPDATE OF title, plain_text ON rss BEGIN
+			INSERT INTO rss_fts (rss_fts, rowid, title, plain_text) VALUES ('delete', old.id, old.title, old.plain_text);
+			INSERT INTO rss_fts (rowid, title, plain_text) VALUES (new.id, new.title, new.plain_text);
+		END`,
+		`INSERT INTO rss_fts (rss_fts) VALUES ('rebuild')`,
+	}
+	for _, statement := range statements {
+		_, err := tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Перевод полнотекстового индекса на приведённые заголовок и текст.
+// Токенизатор unicode61 убирает диакритику только у латиницы, поэтому
+// "ё" и "е" совпадают лишь после foldText.
+func foldSQLiteFTS(tx *sql.Tx) error {
+	for _, column := range []string{"search_title", "search_text"} {
+		err := ensureColumn(tx, "rss", column, "TEXT")
+		if err != nil {
+			return err
+		}
+	}
+	err := fillSearchText(tx, sqliteDialect)
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`DROP TRIGGER IF EXISTS rss_fts_insert`,
+		`DROP TRIGGER IF EXISTS rss_fts_delete`,
+		`DROP TRIGGER IF EXISTS rss_fts_update`,
+		`DROP TABLE IF EXISTS rss_fts`,
+		`CREATE VIRTUAL TABLE rss_fts USING fts5(
+			search_title, search_text,
+			content='rss', content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2'
+		)`,
+		`CREATE TRIGGER rss_fts_insert AFTER INSERT ON rss BEGIN
+			INSERT INTO rss_fts (rowid, search_title, search_text) VALUES (new.id, new.search_title, new.search_text);
+		END`,
+		`CREATE TRIGGER rss_fts_delete AFTER DELETE ON rss BEGIN
+			INSERT INTO rss_fts (rss_fts, rowid, search_title, search_text) VALUES ('delete', old.id, old.search_title, old.search_text);
+		END`,
+		`CREATE TRIGGER rss_fts_update AFTER UPDATE OF search_title, search_text ON rss BEGIN
+			INSERT INTO rss_fts (rss_fts, rowid, search_title, search_text) VALUES ('delete', old.id, old.search_title, old.search_text);
+			INSERT INTO rss_fts (rowid, search_title, search_text) VALUES (new.id, new.search_title, new.search_text);
+		END`,
+		`INSERT INTO rss_fts (rss_fts) VALUES ('rebuild')`,
+	}
+	for _, statement := range statements {
+		_, err := tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Заполнение текста для поиска у публикаций, сохранённых без него
+func fillPlainText(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, COALESCE(description, ''), COALESCE(content, '') FROM rss WHERE plain_text IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	texts := map[int]string{}
+	for rows.Next() {
+		var id int
+		var item Item
+		err := rows.Scan(&id, &item.Description, &item.Content)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		texts[id] = plainText(item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, text := range texts {
+		_, err := tx.Exec(`UPDATE rss SET plain_text = ? WHERE id = ?`, text, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Приведение дат, сохранённых в исходном виде из ленты, к формату хранения.
+// Пустые, отсутствующие и неразборчивые даты заменяются временем миграции.
+func normalizeStoredDates(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, pubDate FROM rss WHERE pubDate IS NULL OR pubDate NOT LIKE '____-__-__T__:__:__Z'`)
+	if err != nil {
+		return err
+	}
+
+	dates := map[int]string{}
+	for rows.Next() {
+		var id int
+		var pubDate sql.NullString
+		err := rows.Scan(&id, &pubDate)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		dates[id] = pubDate.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	migratedAt := time.Now().UTC().Format(storedDateLayout)
+	for id, pubDate := range dates {
+		stored := migratedAt
+		t, err := parsePubDate(pubDate)
+		if err == nil {
+			stored = t.UTC().Format(storedDateLayout)
+		}
+		_, err = tx.Exec(`UPDATE rss SET pubDate = ? WHERE id = ?`, stored, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Добавление столбца в таблицу, если его ещё нет
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// Строки, сохранённые до учёта GUID, имеют ключ по ссылке.
+// Они получают новый ключ, чтобы публикация не задвоилась.
+func (s *sqliteStorage) adoptLegacyKey(ctx context.Context, tx *sql.Tx, item Item) error {
+	key := itemKey(item)
+	link := normalizeURL(item.Link)
+	if item.Link == "" || key == link {
+		return nil
+	}
+
+	// Ключ старой строки — ссылка в исходном виде или уже нормализованная
+	// миграцией normalizeStoredKeys
+	_, err := tx.ExecContext(ctx, `UPDATE OR IGNORE rss SET uid = ?, guid = ? WHERE uid IN (?, ?) AND guid IS NULL`,
+		key, strings.TrimSpace(item.GUID.Value), item.Link, link)
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Файл базы со схемой до появления миграций и с заданными строками
+func legacyDB(t *testing.T, rows ...[]interface{}) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rss.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("opening legacy db: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE rss (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"title" TEXT,
+		"description" TEXT,
+		"link" TEXT,
+		"pubDate" DATETIME
+	)`)
+	if err != nil {
+		t.Fatalf("creating legacy table: %v", err)
+	}
+	for _, row := range rows {
+		_, err := db.Exec(`INSERT INTO rss (title, description, link, pubDate) VALUES (?, ?, ?, ?)`, row...)
+		if err != nil {
+			t.Fatalf("inserting legacy row: %v", err)
+		}
+	}
+	return path
+}
+
+// Хранилище поверх файла базы; закрывается в конце теста
+func openSQLite(t *testing.T, path string) *sqliteStorage {
+	t.Helper()
+	s, err := newSQLiteStorage(path)
+	if err != nil {
+		t.Fatalf("opening storage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Повторы одной ссылки в старой базе не мешают созданию уникального индекса
+func TestSQLiteMigrateDuplicateLinks(t *testing.T) {
+	path := legacyDB(t,
+		[]interface{}{"First", "", "https://example.com/a", "2006-01-02T15:04:05Z"},
+		[]interface{}{"First again", "", "https://example.com/a", "2006-01-02T15:04:05Z"},
+		[]interface{}{"Second", "", "https://example.com/b", "2006-01-03T15:04:05Z"},
+	)
+	s := openSQLite(t, path)
+
+	if items := storedItems(t, s); len(items) != 3 {
+		t.Fatalf("stored %d items after migration, want 3", len(items))
+	}
+
+	added, err := s.InsertItems(context.Background(), []Item{{Title: "First", Link: "https://example.com/a"}}, time.Now())
+	if err != nil {
+		t.Fatalf("InsertItems() error = %v", err)
+	}
+	if len(added) != 0 {
+		t.Errorf("re-added %d items with a legacy link, want 0", len(added))
+	}
+}
+
+// Одновременная вставка одной публикации сохраняет её один раз
+func TestSQLiteConcurrentInsert(t *testing.T) {
+	s := openSQLite(t, filepath.Join(t.TempDir(), "rss.db"))
+	item := Item{Title: "Story", Link: "https://example.com/story"}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			added, err := s.InsertItems(context.Background(), []Item{item}, time.Now())
+			if err != nil {
+				t.Errorf("InsertItems() error = %v", err)
+				return
+			}
+			mu.Lock()
+			total += len(added)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if total != 1 {
+		t.Errorf("reported %d added items, want 1", total)
+	}
+	if items := storedItems(t, s); len(items) != 1 {
+		t.Errorf("stored %d items, want 1", len(items))
+	}
+}
+
+// Старая строка с нормализованным миграцией ключом получает ключ по GUID,
+// а не добавляется повторно
+func TestSQLiteAdoptNormalizedLegacyKey(t *testing.T) {
+	link := "https://example.com/a?utm_source=feed"
+	s := openSQLite(t, legacyDB(t, []interface{}{"Story", "", link, "2006-01-02T15:04:05Z"}))
+
+	item := Item{Title: "Story", Link: link, GUID: GUID{Value: "story-1", IsPermaLink: "false"}}
+	added, err := s.InsertItems(context.Background(), []Item{item}, time.Now())
+	if err != nil {
+		t.Fatalf("InsertItems() error = %v", err)
+	}
+	if len(added) != 0 {
+		t.Errorf("re-added %d legacy items, want 0", len(added))
+	}
+	items := storedItems(t, s)
+	if len(items) != 1 {
+		t.Fatalf("stored %d items, want 1", len(items))
+	}
+	if items[0].GUID.Value != "story-1" {
+		t.Errorf("guid = %q, want %q", items[0].GUID.Value, "story-1")
+	}
+}
+
+// Даты, которые не удаётся разобрать, получают время миграции
+func TestSQLiteMigrateBadDates(t *testing.T) {
+	path := legacyDB(t,
+		[]interface{}{"Valid", "", "https://example.com/valid", "Mon, 02 Jan 2006 15:04:05 +0300"},
+		[]interface{}{"Unparseable", "", "https://example.com/bad", "not a date"},
+		[]interface{}{"Empty", "", "https://example.com/empty", ""},
+		[]interface{}{"Null", "", "https://example.com/null", nil},
+	)
+	before := time.Now().UTC().Truncate(time.Second)
+	s := openSQLite(t, path)
+	after := time.Now().UTC()
+
+	items := storedItems(t, s)
+	if len(items) != 4 {
+		t.Fatalf("stored %d items, want 4", len(items))
+	}
+	if items[0].PubDate != "2006-01-02T12:04:05Z" {
+		t.Errorf("valid pubDate = %q, want %q", items[0].PubDate, "2006-01-02T12:04:05Z")
+	}
+	for _, item := range items[1:] {
+		got, err := time.Parse(storedDateLayout, item.PubDate)
+		if err != nil {
+			t.Errorf("%s: pubDate = %q, not in the stored layout", item.Title, item.PubDate)
+			continue
+		}
+		if got.Before(before) || got.After(after) {
+			t.Errorf("%s: pubDate = %q, want migration time", item.Title, item.PubDate)
+		}
+		if item.PubDate != items[1].PubDate {
+			t.Errorf("%s: pubDate = %q, want the same fallback %q", item.Title, item.PubDate, items[1].PubDate)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
+)
+
+// Политика очистки HTML из лент: оставляет безопасную разметку и ссылки
+var htmlPolicy = bluemonday.UGCPolicy()
+
+// Очищать ли description и content перед сохранением
+var sanitizeHTML bool
+
+// Очистка HTML от скриптов, обработчиков событий и прочего опасного содержимого
+func sanitize(s string) string {
+	return htmlPolicy.Sanitize(s)
+}
+
+// Видимый текст HTML-фрагмента без тегов, со схлопнутыми пробелами
+func htmlToText(s string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(s))
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if tag := string(name); tag == "script" || tag == "style" {
+				skip++
+			}
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if tag := string(name); (tag == "script" || tag == "style") && skip > 0 {
+				skip--
+			}
+			b.WriteByte(' ')
+		case html.SelfClosingTagToken:
+			b.WriteByte(' ')
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// Текст публикации для поиска: описание и полный текст без разметки
+func plainText(item Item) string {
+	text := htmlToText(item.Description)
+	if item.Content != "" && item.Content != item.Description {
+		text += "\n" + htmlToText(item.Content)
+	}
+	return text
+}
+
+// Первое предложение текста, не длиннее ста символов
+func firstSentence(text string) string {
+	for i, r := range text {
+		if (r == '.' || r == '!' || r == '?') && (i+1 == len(text) || text[i+1] == ' ') {
+			text = text[:i+1]
+			break
+		}
+	}
+	return truncate(text, 100)
+}
+
+// Скорость чтения, по которой оценивается время чтения
+const wordsPerMinute = 200
+
+// Число слов в тексте без разметки
+func wordCount(text string) int {
+	return len(strings.Fields(text))
+}
+
+// Время чтения в минутах с округлением вверх; 0 — текста нет
+func readingTime(words int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// Анонс описания: текст без разметки, сокращённый до n символов
+// по границе слова с многоточием. Разметка убирается всегда,
+// чтобы обрезка не оставила незакрытых тегов.
+func previewText(description string, n int) string {
+	text := htmlToText(description)
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	cut := string(runes[:n])
+	if space := strings.LastIndexByte(cut, ' '); space > 0 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
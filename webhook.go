@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Адрес, на который отправляются новые публикации; пустой — не отправляются
+var webhookURL string
+
+// Клиент для уведомлений отделён от клиента лент: у него свой
+// короткий таймаут, а прокси и политика перенаправлений лент
+// к нему не относятся
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// Число попыток доставки уведомления и задержка перед второй из них
+const (
+	webhookAttempts = 3
+	webhookDelay    = time.Second
+)
+
+// Уведомление о новых публикациях одной загрузки ленты:
+// один POST с массивом публикаций в JSON. Отправка идёт через
+// webhookQueue и не задерживает опрос. Неудача доставки
+// не считается ошибкой загрузки и только попадает в журнал.
+func notifyWebhook(items []Item) {
+	target := webhookURL
+	if target == "" || len(items) == 0 {
+		return
+	}
+	webhookQueue.push(func(ctx context.Context) {
+		err := postJSON(ctx, target, items)
+		if err != nil {
+			slog.Warn("Webhook notification failed", "items", len(items), "error", err)
+		}
+	})
+}
+
+// Сколько уведомлений может ждать отправки в очереди
+const deliveryQueueSize = 100
+
+var webhookQueue = newDeliveryQueue("webhook", deliveryQueueSize)
+
+// Очередь уведомлений, которые отправляются по одному в своей горутине.
+// Загрузка ленты только ставит уведомление в очередь, поэтому повторы
+// и таймауты недоступного получателя не занимают слотов опроса.
+type deliveryQueue struct {
+	name string
+	jobs chan func(ctx context.Context)
+}
+
+func newDeliveryQueue(name string, size int) *deliveryQueue {
+	return &deliveryQueue{name: name, jobs: make(chan func(ctx context.Context), size)}
+}
+
+// Постановка уведомления в очередь; из переполненной очереди
+// уведомление пропускается, а не ждёт места
+func (q *deliveryQueue) push(job func(ctx context.Context)) {
+	select {
+	case q.jobs <- job:
+	default:
+		slog.Warn("Notification dropped: delivery queue is full", "queue", q.name)
+	}
+}
+
+// Отправка уведомлений из очереди до отмены контекста
+func (q *deliveryQueue) run(ctx context.Context) {
+	for {
+		var job func(ctx context.Context)
+		select {
+		case <-ctx.Done():
+		case job = <-q.jobs:
+		}
+
+		// Из двух готовых случаев select выбирает любой, поэтому
+		// отмена проверяется и после получения уведомления
+		if ctx.Err() != nil {
+			pending := len(q.jobs)
+			if job != nil {
+				pending++
+			}
+			if pending > 0 {
+				slog.Warn("Notifications not delivered before shutdown", "queue", q.name, "pending", pending)
+			}
+			return
+		}
+		job(ctx)
+	}
+}
+
+// Отправка JSON с повторами при сетевых ошибках, ответах 5xx и 429
+func postJSON(ctx context.Context, target string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := postOnce(ctx, target, body)
+		if !retry || attempt >= webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Одна попытка отправки; retry сообщает, стоит ли её повторить
+func postOnce(ctx context.Context, target string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		// В адресе вебхука обычно зашит секрет, поэтому в ошибку он не попадает
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Недоступный вебхук не задерживает загрузку ленты:
+// уведомление уходит из очереди уже после неё
+func TestWebhookDoesNotBlockFetch(t *testing.T) {
+	useMemoryStore(t)
+	feed := serveFixture(t, "rss.xml")
+
+	release := make(chan struct{})
+	received := make(chan []map[string]interface{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var items []map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&items)
+		received <- items
+	}))
+	t.Cleanup(hook.Close)
+
+	previous := webhookURL
+	webhookURL = hook.URL
+	t.Cleanup(func() { webhookURL = previous })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go webhookQueue.run(ctx)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fetchFeed(context.Background(), feed.Client(), Feed{URL: feed.URL})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("fetchFeed() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatal("fetchFeed waited for the webhook")
+	}
+
+	close(release)
+	select {
+	case items := <-received:
+		if len(items) != 2 {
+			t.Errorf("webhook got %d items, want 2", len(items))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not notified")
+	}
+}